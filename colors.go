@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// how many colors the terminal can show: 4 (the 16 ansi ones), 8 (256) or 24 (truecolor)
+var colorDepth = detectColorDepth()
+
+// guesses the color depth from the environment, terminals don't have a way to ask
+func detectColorDepth() int {
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return 24
+	}
+	if os.Getenv("WT_SESSION") != "" { // windows terminal does truecolor but doesn't say so
+		return 24
+	}
+	if strings.Contains(os.Getenv("TERM"), "256color") {
+		return 8
+	}
+	return 4
+}
+
+// the xterm defaults for the 16 ansi colors, for picking the closest one
+var basicPalette = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0}, {0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0}, {92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// levels of the 6x6x6 color cube in the 256 color palette
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// parses "#rrggbb"
+func parseHexColor(color string) ([3]int, bool) {
+	if len(color) != 7 || color[0] != '#' {
+		return [3]int{}, false
+	}
+	n, err := strconv.ParseUint(color[1:], 16, 32)
+	if err != nil {
+		return [3]int{}, false
+	}
+	return [3]int{int(n >> 16 & 0xff), int(n >> 8 & 0xff), int(n & 0xff)}, true
+}
+
+// parses a 256 color palette number, "0" to "255"
+func parsePaletteColor(color string) (int, bool) {
+	n, err := strconv.Atoi(color)
+	if err != nil || n < 0 || n > 255 || strconv.Itoa(n) != color {
+		return 0, false
+	}
+	return n, true
+}
+
+// whether color is an ansi color name, a 256 color number or #rrggbb
+func isValidColor(color string) bool {
+	if _, ok := ansiColors[color]; ok {
+		return true
+	}
+	if _, ok := parseHexColor(color); ok {
+		return true
+	}
+	_, ok := parsePaletteColor(color)
+	return ok
+}
+
+// what a 256 color palette entry looks like
+func paletteRGB(n int) [3]int {
+	switch {
+	case n < 16:
+		return basicPalette[n]
+	case n < 232:
+		n -= 16
+		return [3]int{cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]}
+	default:
+		gray := 8 + (n-232)*10
+		return [3]int{gray, gray, gray}
+	}
+}
+
+func colorDistance(a, b [3]int) int {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
+
+// the closest 256 color palette entry, from the cube or the gray ramp
+func nearestPaletteColor(rgb [3]int) int {
+	best, bestDistance := 0, -1
+	for n := 16; n < 256; n++ {
+		if d := colorDistance(rgb, paletteRGB(n)); bestDistance < 0 || d < bestDistance {
+			best, bestDistance = n, d
+		}
+	}
+	return best
+}
+
+// the closest of the 16 ansi colors, as an escape code
+func nearestBasicColor(rgb [3]int) string {
+	best, bestDistance := 0, -1
+	for n, c := range basicPalette {
+		if d := colorDistance(rgb, c); bestDistance < 0 || d < bestDistance {
+			best, bestDistance = n, d
+		}
+	}
+	if best < 8 {
+		return fmt.Sprintf("\033[%dm", 30+best)
+	}
+	return fmt.Sprintf("\033[%dm", 90+best-8)
+}
+
+// the escape code for a color name, 256 color number or #rrggbb, brought down to what the terminal
+// can show. empty if it isn't a color
+func colorCode(color string) string {
+	if code, ok := ansiColors[color]; ok {
+		return code
+	}
+	if noColor && isValidColor(color) {
+		return "\033[39m" // the default color
+	}
+	if rgb, ok := parseHexColor(color); ok {
+		switch colorDepth {
+		case 24:
+			return fmt.Sprintf("\033[38;2;%d;%d;%dm", rgb[0], rgb[1], rgb[2])
+		case 8:
+			return fmt.Sprintf("\033[38;5;%dm", nearestPaletteColor(rgb))
+		}
+		return nearestBasicColor(rgb)
+	}
+	if n, ok := parsePaletteColor(color); ok {
+		if colorDepth >= 8 {
+			return fmt.Sprintf("\033[38;5;%dm", n)
+		}
+		return nearestBasicColor(paletteRGB(n))
+	}
+	return ""
+}
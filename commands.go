@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// a // command the client knows
+type clientCommand struct {
+	run     func(conn net.Conn, cmdLine string, args []string) // cmdLine is everything after the //, args its words after the name
+	userArg bool                                               // the first argument is a username, for tab completion
+}
+
+// every // command, key: its name. main runs them and Tab completes them from here
+var commands = map[string]clientCommand{
+	"back":     {run: backCommand},
+	"bye":      {run: exitCommand},
+	"clear":    {run: clearCommand},
+	"color":    {run: colorCommand},
+	"delete":   {run: deleteCommand},
+	"dm":       {run: msgCommand, userArg: true},
+	"edit":     {run: editCommand},
+	"exit":     {run: exitCommand},
+	"history":  {run: historyCommand},
+	"layout":   {run: layoutCommand},
+	"mentions": {run: mentionsCommand},
+	"msg":      {run: msgCommand, userArg: true},
+	"mute":     {run: muteCommand, userArg: true},
+	"mutelist": {run: mutelistCommand},
+	"ping":     {run: pingCommand},
+	"quit":     {run: exitCommand},
+	"react":    {run: reactCommand},
+	"reply":    {run: replyCommand},
+	"save":     {run: saveCommand},
+	"scroll":   {run: scrollCommand},
+	"search":   {run: searchCommand},
+	"theme":    {run: themeCommand},
+	"thread":   {run: threadCommand},
+	"unmute":   {run: unmuteCommand, userArg: true},
+}
+
+// //clear, empties the chat
+func clearCommand(conn net.Conn, cmdLine string, args []string) {
+	clearMessages()
+	addServerMessage("Chat cleared.")
+	redrawMessages()
+}
+
+// //color, changes the color of our name
+func colorCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 1 {
+		addServerMessage("Usage: //color <color>", "bold_red")
+		redrawMessages()
+		return
+	}
+	newColor := validateColorName(args[0])
+	if newColor != config["color"] {
+		config["color"] = newColor
+		addServerMessage(fmt.Sprintf("Color changed to %s.", newColor), "bold_green")
+	} else {
+		addServerMessage(fmt.Sprintf("Color is already set to %s.", newColor), "bold_yellow")
+	}
+	redrawMessages()
+}
+
+// //ping, measures the round trip to the server
+func pingCommand(conn net.Conn, cmdLine string, args []string) {
+	sendPing(conn)
+}
+
+// //mute, hides a user's messages
+func muteCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 1 {
+		addServerMessage("Usage: //mute <username>", "bold_red")
+		redrawMessages()
+		return
+	}
+	userToMute := args[0]
+	if userToMute == config["username"].(string) {
+		addServerMessage("You cannot mute yourself.", "bold_red")
+		redrawMessages()
+		return
+	}
+	if muteList[userToMute] {
+		addServerMessage(fmt.Sprintf("User %s is already muted.", userToMute), "bold_yellow")
+	} else {
+		addMute(userToMute)
+	}
+}
+
+// //unmute, shows a muted user's messages again
+func unmuteCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 1 {
+		addServerMessage("Usage: //unmute <username>", "bold_red")
+		redrawMessages()
+		return
+	}
+	userToUnmute := args[0]
+	if userToUnmute == config["username"].(string) {
+		addServerMessage("You cannot unmute yourself.", "bold_red")
+		redrawMessages()
+		return
+	}
+	if _, exists := muteList[userToUnmute]; !exists {
+		addServerMessage(fmt.Sprintf("User %s is not muted.", userToUnmute), "bold_yellow")
+	} else {
+		removeMute(userToUnmute)
+	}
+}
+
+// //reply, replies to a message by its ID
+func replyCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 2 {
+		addServerMessage("Usage: //reply <id> <message>", "bold_red")
+		redrawMessages()
+		return
+	}
+	replyTo := parseMessageID(strings.TrimPrefix(args[0], "#"))
+	if replyTo == 0 {
+		addServerMessage(fmt.Sprintf("Invalid message ID: %s", args[0]), "bold_red")
+		redrawMessages()
+		return
+	}
+	sendReply(conn, replyTo, commandText(cmdLine, 2))
+	redrawMessages()
+}
+
+// //edit, replaces the text of one of our messages
+func editCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 2 {
+		addServerMessage("Usage: //edit <id> <message>", "bold_red")
+		redrawMessages()
+		return
+	}
+	if !hasCapability(serverCapabilities, "edit") {
+		addServerMessage("This server doesn't support editing messages.", "bold_red")
+		redrawMessages()
+		return
+	}
+	id := parseMessageID(strings.TrimPrefix(args[0], "#"))
+	if id == 0 {
+		addServerMessage(fmt.Sprintf("Invalid message ID: %s", args[0]), "bold_red")
+		redrawMessages()
+		return
+	}
+	sendJSON(conn, map[string]string{
+		"type":    "edit",
+		"user":    config["username"].(string),
+		"id":      strconv.FormatInt(id, 10),
+		"message": commandText(cmdLine, 2),
+	})
+}
+
+// //delete, deletes one of our messages (or anyone's, for moderators)
+func deleteCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 1 {
+		addServerMessage("Usage: //delete <id>", "bold_red")
+		redrawMessages()
+		return
+	}
+	if !hasCapability(serverCapabilities, "edit") {
+		addServerMessage("This server doesn't support deleting messages.", "bold_red")
+		redrawMessages()
+		return
+	}
+	id := parseMessageID(strings.TrimPrefix(args[0], "#"))
+	if id == 0 {
+		addServerMessage(fmt.Sprintf("Invalid message ID: %s", args[0]), "bold_red")
+		redrawMessages()
+		return
+	}
+	sendJSON(conn, map[string]string{
+		"type": "delete",
+		"user": config["username"].(string),
+		"id":   strconv.FormatInt(id, 10),
+	})
+}
+
+// //react, toggles a reaction on a message
+func reactCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 2 {
+		addServerMessage("Usage: //react <id> <emoji or :shortcode:>", "bold_red")
+		redrawMessages()
+		return
+	}
+	if !hasCapability(serverCapabilities, "reactions") {
+		addServerMessage("This server doesn't support reactions.", "bold_red")
+		redrawMessages()
+		return
+	}
+	id := parseMessageID(strings.TrimPrefix(args[0], "#"))
+	if id == 0 {
+		addServerMessage(fmt.Sprintf("Invalid message ID: %s", args[0]), "bold_red")
+		redrawMessages()
+		return
+	}
+	sendJSON(conn, map[string]string{
+		"type":     "react",
+		"user":     config["username"].(string),
+		"id":       strconv.FormatInt(id, 10),
+		"reaction": args[1],
+	})
+}
+
+// //thread, shows a message and its replies
+func threadCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 1 {
+		addServerMessage("Usage: //thread <id>", "bold_red")
+		redrawMessages()
+		return
+	}
+	if !hasCapability(serverCapabilities, "threads") {
+		addServerMessage("This server doesn't support threads.", "bold_red")
+		redrawMessages()
+		return
+	}
+	rootID := parseMessageID(strings.TrimPrefix(args[0], "#"))
+	if rootID == 0 {
+		addServerMessage(fmt.Sprintf("Invalid message ID: %s", args[0]), "bold_red")
+		redrawMessages()
+		return
+	}
+	sendJSON(conn, map[string]string{
+		"type": "threadRequest",
+		"user": config["username"].(string),
+		"id":   strconv.FormatInt(rootID, 10),
+	})
+}
+
+// //history, fetches older messages from the server
+func historyCommand(conn net.Conn, cmdLine string, args []string) {
+	if !hasCapability(serverCapabilities, "history") {
+		addServerMessage("This server doesn't support fetching history.", "bold_red")
+		redrawMessages()
+		return
+	}
+	limit := defaultHistoryPageSize
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > maxHistoryPageSize {
+			addServerMessage(fmt.Sprintf("Usage: //history [1-%d]", maxHistoryPageSize), "bold_red")
+			redrawMessages()
+			return
+		}
+		limit = n
+	}
+	requestOlderHistory(conn, limit)
+}
+
+// //search, searches the server's history
+func searchCommand(conn net.Conn, cmdLine string, args []string) {
+	if !hasCapability(serverCapabilities, "search") {
+		addServerMessage("This server doesn't support searching.", "bold_red")
+		redrawMessages()
+		return
+	}
+	query := commandText(cmdLine, 1)
+	if query == "" {
+		addServerMessage("Usage: //search <words> [OR word] [-word] [\"a phrase\"] [from:user] [before:YYYY-MM-DD] [after:YYYY-MM-DD]", "bold_red")
+		redrawMessages()
+		return
+	}
+	sendSearch(conn, query)
+}
+
+// //msg, also //dm, sends a direct message
+func msgCommand(conn net.Conn, cmdLine string, args []string) {
+	if !hasCapability(serverCapabilities, "dm") {
+		addServerMessage("This server doesn't support direct messages.", "bold_red")
+		redrawMessages()
+		return
+	}
+	if len(args) < 2 {
+		addServerMessage("Usage: //msg <username> <message>", "bold_red")
+		redrawMessages()
+		return
+	}
+	sendDirectMessage(conn, strings.TrimPrefix(args[0], "@"), commandText(cmdLine, 2))
+}
+
+// //save, saves every message of this session to a file
+func saveCommand(conn net.Conn, cmdLine string, args []string) {
+	path := commandText(cmdLine, 1)
+	if path == "" {
+		addServerMessage("Usage: //save <file>", "bold_red")
+		redrawMessages()
+		return
+	}
+	count, err := saveSession(path)
+	if err != nil {
+		addServerMessage("Couldn't save the session: "+err.Error(), "bold_red")
+	} else {
+		addServerMessage(fmt.Sprintf("Saved %d messages to %s", count, path), "bold_green")
+	}
+	redrawMessages()
+}
+
+// //mentions, shows the messages that mentioned us, or forgets them
+func mentionsCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) > 0 && args[0] == "clear" {
+		screenMutex.Lock()
+		mentions = nil
+		unseenMentions = 0
+		screenMutex.Unlock()
+		addServerMessage("Mentions cleared.", "bold_yellow")
+		redrawMessages()
+		return
+	}
+	showMentions()
+}
+
+// //scroll, moves through the scrollback
+func scrollCommand(conn net.Conn, cmdLine string, args []string) {
+	if plainMode {
+		addServerMessage("There's nothing to scroll in plain mode, everything is in your terminal's scrollback.", "bold_yellow")
+		redrawMessages()
+		return
+	}
+	direction := ""
+	if len(args) > 0 {
+		direction = args[0]
+	}
+	lines := scrollPage()
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			direction = "" // falls through to the usage below
+		}
+		lines = n
+	}
+	switch direction {
+	case "up":
+		scrollBy(lines)
+	case "down":
+		scrollBy(-lines)
+	case "top":
+		scrollToTop()
+	case "bottom":
+		scrollToBottom()
+	default:
+		addServerMessage("Usage: //scroll up|down [lines], //scroll top or //scroll bottom", "bold_red")
+		redrawMessages()
+	}
+}
+
+// //theme, lists the themes or switches to one
+func themeCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(args) < 1 {
+		current, _ := config["theme"].(string)
+		if current == "" {
+			current = "default"
+		}
+		addServerMessage(fmt.Sprintf("Themes: %s (using %s). //theme <name> to switch.", strings.Join(listThemes(), ", "), current), "bold_yellow")
+		redrawMessages()
+		return
+	}
+	t, err := loadTheme(args[0])
+	if err != nil {
+		addServerMessage(err.Error(), "bold_red")
+		redrawMessages()
+		return
+	}
+	screenMutex.Lock()
+	activeTheme = t
+	config["theme"] = args[0]
+	screenMutex.Unlock()
+	addServerMessage(fmt.Sprintf("Theme changed to %s.", args[0]), "bold_green")
+	redrawMessages()
+}
+
+// //layout, switches between the simple and panes layouts
+func layoutCommand(conn net.Conn, cmdLine string, args []string) {
+	if plainMode {
+		addServerMessage("Layouts don't apply in plain mode, messages are printed one after another.", "bold_yellow")
+		redrawMessages()
+		return
+	}
+	if len(args) < 1 || (args[0] != "simple" && args[0] != "panes") {
+		addServerMessage(fmt.Sprintf("Usage: //layout simple|panes, currently %s", layoutName()), "bold_red")
+		redrawMessages()
+		return
+	}
+	setLayout(args[0])
+	if width, _ := getTerminalSize(); args[0] == "panes" && !panesActive(width) {
+		addServerMessage(fmt.Sprintf("The terminal is too narrow for panes, they'll show up once it's at least %d columns wide.", minPaneWidth), "bold_yellow")
+		redrawMessages()
+	}
+}
+
+// //back, returns to the chat from a view
+func backCommand(conn net.Conn, cmdLine string, args []string) {
+	screenMutex.Lock()
+	activeView = nil
+	screenMutex.Unlock()
+	redrawMessages()
+}
+
+// //mutelist, lists the muted users
+func mutelistCommand(conn net.Conn, cmdLine string, args []string) {
+	if len(muteList) == 0 {
+		addServerMessage("You have no muted users.", "bold_yellow")
+	} else {
+		muteListMsg := "Muted users: "
+		for user := range muteList {
+			muteListMsg += user + ", "
+		}
+		muteListMsg = strings.TrimSuffix(muteListMsg, ", ")
+		addServerMessage(muteListMsg, "bold_yellow")
+		redrawMessages()
+	}
+}
+
+// //exit, also //quit and //bye, quits the client
+func exitCommand(conn net.Conn, cmdLine string, args []string) {
+	exitClient(0, "Exiting chat...")
+}
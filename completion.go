@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const maxSeenUsers = 200 // recently seen users kept for completion
+
+// who's online according to the server, nil if it doesn't send a roster, guarded by screenMutex
+var rosterUsers []string
+
+// users we've seen send something, most recent last, guarded by screenMutex
+var seenUsers []string
+
+// the Tab completion being cycled through, nil when the last key wasn't Tab, guarded by screenMutex
+var activeCompletion *completion
+
+type completion struct {
+	candidates []string // what the word can become, suffix included
+	index      int      // candidate currently in the input
+	start      int      // where the completed word starts in inputBuffer
+	end        int      // where it ends
+}
+
+// the server sent who's online
+func setRoster(jsonMsg map[string]string) {
+	var users []string
+	if err := json.Unmarshal([]byte(jsonMsg["users"]), &users); err != nil {
+		return
+	}
+	screenMutex.Lock()
+	rosterUsers = sanitizeList(users)
+	screenMutex.Unlock()
+}
+
+// remembers a user for completion, caller must hold screenMutex
+func recordSeenUser(user string) {
+	if user == "" || user == "server" {
+		return
+	}
+	for i, seen := range seenUsers {
+		if seen == user {
+			seenUsers = append(seenUsers[:i], seenUsers[i+1:]...)
+			break
+		}
+	}
+	seenUsers = append(seenUsers, user)
+	if len(seenUsers) > maxSeenUsers {
+		seenUsers = seenUsers[len(seenUsers)-maxSeenUsers:]
+	}
+}
+
+// usernames starting with prefix, ignoring case, online ones first, caller must hold screenMutex
+func completeUsers(prefix string) []string {
+	self, _ := config["username"].(string)
+	lower := strings.ToLower(prefix)
+	seen := make(map[string]bool)
+	var online, others []string
+	for _, user := range rosterUsers {
+		if user != self && !seen[user] && strings.HasPrefix(strings.ToLower(user), lower) {
+			online = append(online, user)
+			seen[user] = true
+		}
+	}
+	// most recently seen first
+	for i := len(seenUsers) - 1; i >= 0; i-- {
+		user := seenUsers[i]
+		if user != self && !seen[user] && strings.HasPrefix(strings.ToLower(user), lower) {
+			others = append(others, user)
+			seen[user] = true
+		}
+	}
+	sort.Strings(online)
+	return append(online, others...)
+}
+
+// commands starting with prefix
+func completeCommands(prefix string) []string {
+	var names []string
+	for name := range commands {
+		if strings.HasPrefix(name, strings.ToLower(prefix)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// what the word ending at the cursor could become, and where it starts, caller must hold screenMutex
+func completionCandidates() ([]string, int) {
+	start := inputCursor
+	for start > 0 && inputBuffer[start-1] != ' ' {
+		start--
+	}
+	word := string(inputBuffer[start:inputCursor])
+	line := string(inputBuffer[:start])
+
+	var candidates []string
+	switch {
+	case start == 0 && strings.HasPrefix(word, "//"):
+		for _, name := range completeCommands(word[2:]) {
+			candidates = append(candidates, "//"+name+" ")
+		}
+	case strings.HasPrefix(word, "@"):
+		for _, user := range completeUsers(word[1:]) {
+			candidates = append(candidates, "@"+user+" ")
+		}
+	case strings.HasPrefix(line, "//") && len(strings.Fields(line)) == 1 && strings.HasSuffix(line, " "):
+		// first argument of a command that takes a username
+		name := strings.TrimPrefix(strings.Fields(line)[0], "//")
+		if commands[name].userArg {
+			for _, user := range completeUsers(word) {
+				candidates = append(candidates, user+" ")
+			}
+		}
+	}
+	return candidates, start
+}
+
+// completes the word at the cursor, or moves on to the next candidate if Tab was just pressed, caller must hold screenMutex
+func completeInput() {
+	if activeCompletion == nil {
+		candidates, start := completionCandidates()
+		if len(candidates) == 0 {
+			return
+		}
+		activeCompletion = &completion{candidates: candidates, index: -1, start: start, end: inputCursor}
+	}
+	c := activeCompletion
+	c.index = (c.index + 1) % len(c.candidates)
+	replacement := []rune(c.candidates[c.index])
+
+	rest := append([]rune(nil), inputBuffer[c.end:]...)
+	inputBuffer = append(append(inputBuffer[:c.start], replacement...), rest...)
+	c.end = c.start + len(replacement)
+	inputCursor = c.end
+}
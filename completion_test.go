@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompleteCommands(t *testing.T) {
+	cases := []struct {
+		prefix string
+		want   []string
+	}{
+		{"m", []string{"mentions", "msg", "mute", "mutelist"}},
+		{"MU", []string{"mute", "mutelist"}},
+		{"b", []string{"back", "bye"}},
+		{"quit", []string{"quit"}},
+		{"nope", nil},
+	}
+	for _, c := range cases {
+		if got := completeCommands(c.prefix); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("completeCommands(%q) = %q, want %q", c.prefix, got, c.want)
+		}
+	}
+}
+
+func TestCompleteUserArgument(t *testing.T) {
+	config = map[string]interface{}{"username": "zed"}
+	rosterUsers = []string{"alice", "albert", "zed"}
+	seenUsers = nil
+	defer func() { rosterUsers = nil }()
+
+	cases := []struct {
+		input string
+		want  []string
+	}{
+		{"//mute al", []string{"albert ", "alice "}},
+		{"//dm al", []string{"albert ", "alice "}},
+		{"//react al", nil}, // takes a message ID, not a user
+		{"hi @al", []string{"@albert ", "@alice "}},
+		{"//z", nil},
+	}
+	for _, c := range cases {
+		inputBuffer = []rune(c.input)
+		inputCursor = len(inputBuffer)
+		if got, _ := completionCandidates(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("completionCandidates() for %q = %q, want %q", c.input, got, c.want)
+		}
+	}
+}
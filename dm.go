@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// sends a direct message, the server echoes it back so it shows up in our chat too
+func sendDirectMessage(conn net.Conn, to string, msg string) {
+	sendJSON(conn, map[string]string{
+		"type":    "dm",
+		"user":    config["username"].(string),
+		"to":      to,
+		"message": msg,
+		"color":   validateColorName(config["color"].(string)),
+	})
+}
+
+// builds an entry for a DM, either one sent to us or the echo of one we sent
+func entryFromDirectMessage(jsonMsg map[string]string) *chatEntry {
+	entry := entryFromMessage(jsonMsg)
+	entry.user = jsonMsg["from"]
+	entry.dm = true
+	if jsonMsg["from"] == config["username"] {
+		entry.dmTo = jsonMsg["to"]
+	}
+	return entry
+}
+
+// shows a DM from the server, which is either for us or the echo of one we sent
+func addDirectMessage(jsonMsg map[string]string) {
+	if muteList[jsonMsg["from"]] {
+		return
+	}
+	entry := entryFromDirectMessage(jsonMsg)
+	addEntry(entry)
+	screenMutex.Lock()
+	recordSessionMessage(entry)
+	recordSeenUser(jsonMsg["from"])
+	recordDirectMessage(entry)
+	screenMutex.Unlock()
+
+	switch {
+	case entry.dmTo != "" && jsonMsg["stored"] == "true":
+		addServerMessage(fmt.Sprintf("%s is offline, they'll get your message when they're back.", entry.dmTo), "bold_yellow")
+	case entry.dmTo == "" && jsonMsg["offline"] != "true":
+		setTyping(entry.user, "stop")
+		notifyMention(entry)
+	}
+	redrawMessages()
+}
+
+// shows a message that mentioned us while we were away
+func addMissedMention(jsonMsg map[string]string) {
+	if muteList[jsonMsg["user"]] {
+		return
+	}
+	entry := entryFromMessage(jsonMsg)
+	addEntry(entry)
+	recordMention(entry)
+	redrawMessages()
+}
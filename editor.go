@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+const inputPrompt = "Message: "
+
+// terminal state saved before switching stdin to raw mode
+var oldTermState *term.State
+
+const maxInputHistory = 100 // lines kept for Up/Down
+
+// line editor state, guarded by screenMutex
+var (
+	inputBuffer     []rune   // text the user is currently composing at the input line
+	inputCursor     int      // position of the cursor in inputBuffer
+	inputHistory    []string // lines sent this session, oldest first
+	inputHistoryPos int      // line of inputHistory being shown, len(inputHistory) for the one being written
+	inputDraft      []rune   // what was being written before going back through the history
+)
+
+// switches stdin to raw mode so keystrokes can be read one at a time
+func enableRawMode() bool {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return false
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return false
+	}
+	oldTermState = state
+	return true
+}
+
+// puts the terminal back the way we found it
+func restoreTerminal() {
+	if oldTermState != nil {
+		term.Restore(int(os.Stdin.Fd()), oldTermState)
+		oldTermState = nil
+	}
+}
+
+// restores the terminal and exits, printing msg first if given
+func exitClient(code int, msg string) {
+	restoreTerminal()
+	if msg != "" {
+		fmt.Println(msg)
+	}
+	os.Exit(code)
+}
+
+// draws the input line with whatever is being typed and puts the cursor where it belongs, caller must hold screenMutex
+func drawInputLine() {
+	if plainMode {
+		return // the terminal echoes what's typed
+	}
+	width, height := getTerminalSize()
+	moveCursor(1, height-1)
+	clearLine()
+
+	// lines longer than the terminal scroll sideways to keep the cursor in view,
+	// counting columns rather than runes since wide characters take two
+	room := width - len(inputPrompt) - 1
+	if room < 2 {
+		room = 2
+	}
+	// newlines from Alt+Enter show up as ↵, one rune for one so the cursor stays put
+	shown := []rune(strings.ReplaceAll(string(inputBuffer), "\n", "↵"))
+	start := 0
+	for displayWidth(string(shown[start:inputCursor])) > room {
+		start++
+	}
+	end := start
+	used := 0
+	for end < len(shown) && used+runeWidth(shown[end]) <= room {
+		used += runeWidth(shown[end])
+		end++
+	}
+	fmt.Print(inputPrompt + string(shown[start:end]))
+	moveCursor(len(inputPrompt)+1+displayWidth(string(shown[start:inputCursor])), height-1)
+}
+
+// redraws just the input line
+func redrawInputLine() {
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	drawInputLine()
+}
+
+// replaces the whole input line, with the cursor at the end, caller must hold screenMutex
+func setInput(text []rune) {
+	inputBuffer = append(inputBuffer[:0], text...)
+	inputCursor = len(inputBuffer)
+}
+
+// remembers a sent line for Up/Down, skipping repeats, caller must hold screenMutex
+func addInputHistory(line string) {
+	if line != "" && (len(inputHistory) == 0 || inputHistory[len(inputHistory)-1] != line) {
+		inputHistory = append(inputHistory, line)
+		if len(inputHistory) > maxInputHistory {
+			inputHistory = inputHistory[len(inputHistory)-maxInputHistory:]
+		}
+	}
+	inputHistoryPos = len(inputHistory)
+	inputDraft = nil
+}
+
+// shows the previous (step -1) or next (step 1) line of the input history, caller must hold screenMutex
+func browseInputHistory(step int) {
+	pos := inputHistoryPos + step
+	if pos < 0 || pos > len(inputHistory) {
+		return
+	}
+	if inputHistoryPos == len(inputHistory) {
+		inputDraft = append([]rune(nil), inputBuffer...) // keep what we were writing
+	}
+	inputHistoryPos = pos
+	if pos == len(inputHistory) {
+		setInput(inputDraft)
+	} else {
+		setInput([]rune(inputHistory[pos]))
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// where the word before the cursor starts, skipping any spaces in between
+func wordStartBefore(pos int) int {
+	for pos > 0 && !isWordRune(inputBuffer[pos-1]) {
+		pos--
+	}
+	for pos > 0 && isWordRune(inputBuffer[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+// where the word after the cursor ends, skipping any spaces in between
+func wordEndAfter(pos int) int {
+	for pos < len(inputBuffer) && !isWordRune(inputBuffer[pos]) {
+		pos++
+	}
+	for pos < len(inputBuffer) && isWordRune(inputBuffer[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// removes inputBuffer[from:to], leaving the cursor at from, caller must hold screenMutex
+func deleteInput(from, to int) {
+	if from < 0 || to > len(inputBuffer) || from >= to {
+		return
+	}
+	inputBuffer = append(inputBuffer[:from], inputBuffer[to:]...)
+	inputCursor = from
+}
+
+// applies an editing key to the input line, returns whether the text changed, caller must hold screenMutex
+func editInput(key string) bool {
+	before := string(inputBuffer)
+	switch key {
+	case "left":
+		if inputCursor > 0 {
+			inputCursor--
+		}
+	case "right":
+		if inputCursor < len(inputBuffer) {
+			inputCursor++
+		}
+	case "home":
+		inputCursor = 0
+	case "end":
+		inputCursor = len(inputBuffer)
+	case "wordLeft":
+		inputCursor = wordStartBefore(inputCursor)
+	case "wordRight":
+		inputCursor = wordEndAfter(inputCursor)
+	case "backspace":
+		deleteInput(inputCursor-1, inputCursor)
+	case "delete":
+		deleteInput(inputCursor, inputCursor+1)
+	case "deleteWordBack":
+		deleteInput(wordStartBefore(inputCursor), inputCursor)
+	case "deleteWordForward":
+		deleteInput(inputCursor, wordEndAfter(inputCursor))
+	case "killToStart":
+		deleteInput(0, inputCursor)
+	case "killToEnd":
+		deleteInput(inputCursor, len(inputBuffer))
+	case "historyUp":
+		browseInputHistory(-1)
+	case "historyDown":
+		browseInputHistory(1)
+	case "newline":
+		inputBuffer = append(inputBuffer, 0)
+		copy(inputBuffer[inputCursor+1:], inputBuffer[inputCursor:])
+		inputBuffer[inputCursor] = '\n'
+		inputCursor++
+	}
+	return string(inputBuffer) != before
+}
+
+// editing keys sent as control characters
+var controlKeys = map[rune]string{
+	1:   "home",           // ctrl+a
+	2:   "left",           // ctrl+b
+	5:   "end",            // ctrl+e
+	6:   "right",          // ctrl+f
+	8:   "backspace",      // ctrl+h
+	11:  "killToEnd",      // ctrl+k
+	14:  "historyDown",    // ctrl+n
+	16:  "historyUp",      // ctrl+p
+	21:  "killToStart",    // ctrl+u
+	23:  "deleteWordBack", // ctrl+w
+	127: "backspace",
+}
+
+// editing keys sent as escape sequences, without the escape, terminals don't agree on some of these
+var escapeKeys = map[string]string{
+	"[A": "historyUp", "OA": "historyUp",
+	"[B": "historyDown", "OB": "historyDown",
+	"[C": "right", "OC": "right",
+	"[D": "left", "OD": "left",
+	"[H": "home", "OH": "home", "[1~": "home", "[7~": "home",
+	"[F": "end", "OF": "end", "[4~": "end", "[8~": "end",
+	"[3~":   "delete",
+	"[1;5C": "wordRight", "[1;3C": "wordRight", "f": "wordRight", // ctrl or alt + right, alt+f
+	"[1;5D": "wordLeft", "[1;3D": "wordLeft", "b": "wordLeft", // ctrl or alt + left, alt+b
+	"\x7f": "deleteWordBack",    // alt+backspace
+	"d":    "deleteWordForward", // alt+d
+	"\r":   "newline",           // alt+enter, for multi-line messages and code blocks
+}
+
+// reads one line from stdin in raw mode, echoing it on the input line as it's typed
+func readInputLine(reader *bufio.Reader) (string, error) {
+	screenMutex.Lock()
+	setInput(nil)
+	inputHistoryPos = len(inputHistory)
+	drawInputLine()
+	screenMutex.Unlock()
+
+	for {
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		if r != '\t' {
+			screenMutex.Lock()
+			activeCompletion = nil // any other key ends the cycle
+			screenMutex.Unlock()
+		}
+
+		key := controlKeys[r]
+		switch {
+		case r == '\t': // complete the command or username at the cursor, again to cycle
+			screenMutex.Lock()
+			completeInput()
+			drawInputLine()
+			screenMutex.Unlock()
+			typing.keystroke()
+			continue
+		case r == '\r' || r == '\n': // enter
+			screenMutex.Lock()
+			line := string(inputBuffer)
+			addInputHistory(line)
+			setInput(nil)
+			drawInputLine()
+			screenMutex.Unlock()
+			typing.stop()
+			return line, nil
+		case r == 3: // ctrl+c
+			exitClient(0, "Exiting chat...")
+		case r == 4: // ctrl+d, exits on an empty line, otherwise deletes like the delete key
+			screenMutex.Lock()
+			empty := len(inputBuffer) == 0
+			screenMutex.Unlock()
+			if empty {
+				exitClient(0, "Exiting chat...")
+			}
+			key = "delete"
+		case r == 12: // ctrl+l, redraw everything
+			redrawMessages()
+			continue
+		case r == 0x1b: // escape sequence (arrow keys etc.)
+			seq := readEscapeSequence(reader)
+			switch seq {
+			case "[5~": // page up
+				scrollBy(scrollPage())
+				continue
+			case "[6~": // page down
+				scrollBy(-scrollPage())
+				continue
+			}
+			key = escapeKeys[seq]
+		case unicode.IsPrint(r):
+			screenMutex.Lock()
+			inputBuffer = append(inputBuffer, 0)
+			copy(inputBuffer[inputCursor+1:], inputBuffer[inputCursor:])
+			inputBuffer[inputCursor] = r
+			inputCursor++
+			drawInputLine()
+			screenMutex.Unlock()
+			typing.keystroke()
+			continue
+		}
+		if key == "" {
+			continue
+		}
+
+		screenMutex.Lock()
+		changed := editInput(key)
+		empty := len(inputBuffer) == 0
+		drawInputLine()
+		screenMutex.Unlock()
+		if changed && empty {
+			typing.stop()
+		} else if changed {
+			typing.keystroke()
+		}
+	}
+}
+
+// consumes the rest of an escape sequence so it doesn't end up in the message, returns it without the escape
+func readEscapeSequence(reader *bufio.Reader) string {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return ""
+	}
+	seq := []byte{b}
+	switch b {
+	case '[': // CSI, ends with a byte in 0x40-0x7e
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				break
+			}
+			seq = append(seq, b)
+			if b >= 0x40 && b <= 0x7e {
+				break
+			}
+		}
+	case 'O': // SS3, one more byte
+		if b, err := reader.ReadByte(); err == nil {
+			seq = append(seq, b)
+		}
+	}
+	return string(seq)
+}
+
+// reads the next line the user entered, falling back to plain line reads when stdin isn't a terminal
+func nextInputLine(reader *bufio.Reader) (string, error) {
+	if oldTermState != nil {
+		return readInputLine(reader)
+	}
+	redrawInputLine()
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
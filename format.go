@@ -0,0 +1,212 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ansi attributes for the inline markup, the markers themselves aren't shown
+var markupStyles = map[rune]string{
+	'*': "\033[1m",  // *bold*
+	'_': "\033[3m",  // _italic_
+	'~': "\033[9m",  // ~strike~
+	'`': "\033[36m", // `code`, nothing inside is formatted
+}
+
+const codeFence = "```"
+
+// renders message text for the chat, with inline markup turned into ansi attributes,
+// mentions highlighted and fenced code blocks drawn in a box, wrapped to width
+func renderMarkup(text string, width int) []string {
+	var lines, prose, code []string
+	label := ""
+	inCode := false
+	flushProse := func() {
+		if len(prose) == 0 {
+			return
+		}
+		for i, line := range prose {
+			prose[i] = formatInline(highlightMentions(line))
+		}
+		lines = append(lines, carryStyles(wrapText(strings.Join(prose, "\n"), width))...)
+		prose = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !inCode && strings.HasPrefix(trimmed, codeFence):
+			flushProse()
+			inCode = true
+			label = strings.TrimSpace(strings.TrimPrefix(trimmed, codeFence))
+		case inCode && trimmed == codeFence:
+			lines = append(lines, renderCodeBlock(code, label, width)...)
+			inCode = false
+			code = nil
+		case inCode:
+			code = append(code, line)
+		default:
+			prose = append(prose, line)
+		}
+	}
+	flushProse()
+	if inCode {
+		// never closed, box what there is anyway
+		lines = append(lines, renderCodeBlock(code, label, width)...)
+	}
+	return lines
+}
+
+// turns *bold*, _italic_, ~strike~ and `code` in one line into ansi attributes
+func formatInline(line string) string {
+	runes := []rune(line)
+	var b strings.Builder
+	var open []rune // markers currently in effect, outermost first
+	isOpen := func(marker rune) bool {
+		for _, m := range open {
+			if m == marker {
+				return true
+			}
+		}
+		return false
+	}
+	active := func() string {
+		codes := ""
+		for _, m := range open {
+			codes += markupStyles[m]
+		}
+		return codes
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		inCode := isOpen('`')
+		switch {
+		case r == 0x1b:
+			// copy color codes (mentions) through, putting our attributes back after a reset
+			j := i + 1
+			for j < len(runes) && (runes[j] < 0x40 || runes[j] > 0x7e || runes[j] == '[') {
+				j++
+			}
+			seq := string(runes[i:min(j+1, len(runes))])
+			b.WriteString(seq)
+			if seq == ansiColors["reset"] {
+				b.WriteString(active())
+			}
+			i = j
+		case markupStyles[r] == "" || (inCode && r != '`'):
+			b.WriteRune(r)
+		case isOpen(r) && canCloseMarkup(runes, i):
+			for k := len(open) - 1; k >= 0; k-- {
+				if open[k] == r {
+					open = append(open[:k], open[k+1:]...)
+					break
+				}
+			}
+			b.WriteString(ansiColors["reset"] + active())
+		case !isOpen(r) && canOpenMarkup(runes, i) && closingMarkup(runes, i) > 0:
+			open = append(open, r)
+			b.WriteString(markupStyles[r])
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if len(open) > 0 {
+		b.WriteString(ansiColors["reset"])
+	}
+	return b.String()
+}
+
+// a marker opens at the start of a word, and not when doubled like ** or __
+func canOpenMarkup(runes []rune, i int) bool {
+	if i > 0 && (isWordRune(runes[i-1]) || runes[i-1] == runes[i]) {
+		return false
+	}
+	return i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && runes[i+1] != runes[i]
+}
+
+// a marker closes at the end of a word, so snake_case stays as it is
+func canCloseMarkup(runes []rune, i int) bool {
+	if i == 0 || unicode.IsSpace(runes[i-1]) || runes[i-1] == runes[i] {
+		return false
+	}
+	return i+1 == len(runes) || (!isWordRune(runes[i+1]) && runes[i+1] != runes[i])
+}
+
+// where the marker opened at i is closed, 0 if it never is so it's shown as typed
+func closingMarkup(runes []rune, i int) int {
+	for j := i + 2; j < len(runes); j++ {
+		if runes[j] == runes[i] && canCloseMarkup(runes, j) {
+			return j
+		}
+	}
+	return 0
+}
+
+// starts every wrapped line with the attributes left on at the end of the one before,
+// and turns them off at the end of each line so they don't leak into the next thing drawn
+func carryStyles(lines []string) []string {
+	active := ""
+	for i, line := range lines {
+		lines[i] = active + line
+		for j := 0; j < len(line); j++ {
+			if line[j] != 0x1b {
+				continue
+			}
+			end := j + 1
+			for end < len(line) && (line[end] < 0x40 || line[end] > 0x7e || line[end] == '[') {
+				end++
+			}
+			if end == len(line) {
+				break
+			}
+			seq := line[j : end+1]
+			if seq == ansiColors["reset"] {
+				active = ""
+			} else {
+				active += seq
+			}
+			j = end
+		}
+		if active != "" {
+			lines[i] += ansiColors["reset"]
+		}
+	}
+	return lines
+}
+
+// draws a fenced code block in a box, as typed apart from tabs
+func renderCodeBlock(code []string, label string, width int) []string {
+	inner := width - 4 // "│ " and " │"
+	if inner < 1 {
+		inner = 1
+	}
+	var wrapped []string
+	for _, line := range code {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		if strings.TrimSpace(line) == "" {
+			wrapped = append(wrapped, "")
+			continue
+		}
+		wrapped = append(wrapped, wrapText(line, inner)...)
+	}
+	// as wide as the longest line, or the label
+	boxWidth := displayWidth(label) + 2
+	for _, line := range wrapped {
+		boxWidth = max(boxWidth, displayWidth(line))
+	}
+	boxWidth = min(boxWidth, inner)
+
+	dim := "\033[2m"
+	reset := ansiColors["reset"]
+	top := "─" + strings.Repeat("─", boxWidth+1)
+	if label != "" {
+		label = truncateToWidth(label, boxWidth-2)
+		top = "─ " + label + " " + strings.Repeat("─", boxWidth-displayWidth(label)-1)
+	}
+	lines := []string{dim + "┌" + top + "┐" + reset}
+	for _, line := range wrapped {
+		lines = append(lines, dim+"│ "+reset+markupStyles['`']+fitToWidth(line, boxWidth)+reset+dim+" │"+reset)
+	}
+	return append(lines, dim+"└"+strings.Repeat("─", boxWidth+2)+"┘"+reset)
+}
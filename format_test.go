@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	bold   = "\033[1m"
+	italic = "\033[3m"
+	strike = "\033[9m"
+	code   = "\033[36m"
+	reset  = "\033[0m"
+	dim    = "\033[2m"
+)
+
+func TestFormatInline(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"plain", "just text", "just text"},
+		{"bold", "*bold*", bold + "bold" + reset},
+		{"italic", "_it_", italic + "it" + reset},
+		{"strike", "~gone~", strike + "gone" + reset},
+		{"code", "`x := 1`", code + "x := 1" + reset},
+		{"inside a sentence", "this is *very* good", "this is " + bold + "very" + reset + " good"},
+		{"nested", "*bold _both_ bold*", bold + "bold " + italic + "both" + reset + bold + " bold" + reset},
+		{"overlapping", "*a _b* c_", bold + "a " + italic + "b" + reset + italic + " c" + reset},
+		{"nothing inside code", "`*not bold*`", code + "*not bold*" + reset},
+		{"code inside bold", "*see `f`*", bold + "see " + code + "f" + reset + bold + reset},
+		{"unterminated", "*unterminated", "*unterminated"},
+		{"unterminated inside closed", "*bold _x*", bold + "bold _x" + reset},
+		{"unterminated after closed", "*a* and *b", bold + "a" + reset + " and *b"},
+		{"snake_case", "snake_case_name", "snake_case_name"},
+		{"doubled", "**not bold**", "**not bold**"},
+		{"arithmetic", "2 * 3 * 4", "2 * 3 * 4"},
+		{"empty", "**", "**"},
+		{"color codes pass through", "*a \033[31mred" + reset + " b*", bold + "a \033[31mred" + reset + bold + " b" + reset},
+	}
+	for _, c := range cases {
+		if got := formatInline(c.line); got != c.want {
+			t.Errorf("%s: formatInline(%q) = %q, want %q", c.name, c.line, got, c.want)
+		}
+	}
+}
+
+func TestRenderMarkup(t *testing.T) {
+	config = map[string]interface{}{"username": "zed", "themeColor": "blue"}
+	mention := mentionColorCode()
+
+	cases := []struct {
+		name  string
+		text  string
+		width int
+		want  []string
+	}{
+		{"plain", "hello world", 20, []string{"hello world"}},
+		{"wraps", "hello world", 5, []string{"hello", "world"}},
+		{"styles carry over a wrap", "*aaa bbb*", 4, []string{bold + "aaa" + reset, bold + "bbb" + reset}},
+		{"mention", "hi @zed", 20, []string{"hi " + mention + "@zed" + reset}},
+		{"mention in bold", "*hey @zed*", 20, []string{bold + "hey " + mention + "@zed" + reset + bold + reset}},
+		{"each line on its own", "*a\nb*", 20, []string{"*a", "b*"}},
+		{
+			"code block", "```go\nx := *1*\n```", 20,
+			[]string{
+				dim + "┌─ go ─────┐" + reset,
+				dim + "│ " + reset + code + "x := *1*" + reset + dim + " │" + reset,
+				dim + "└──────────┘" + reset,
+			},
+		},
+		{
+			"unclosed code block", "before\n```\nx", 20,
+			[]string{
+				"before",
+				dim + "┌────┐" + reset,
+				dim + "│ " + reset + code + "x " + reset + dim + " │" + reset,
+				dim + "└────┘" + reset,
+			},
+		},
+		{
+			"long code lines wrap inside the box", "```\nabcdefgh\n```", 8,
+			[]string{
+				dim + "┌──────┐" + reset,
+				dim + "│ " + reset + code + "abcd" + reset + dim + " │" + reset,
+				dim + "│ " + reset + code + "efgh" + reset + dim + " │" + reset,
+				dim + "└──────┘" + reset,
+			},
+		},
+	}
+	for _, c := range cases {
+		if got := renderMarkup(c.text, c.width); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: renderMarkup(%q, %d) =\n%q\nwant\n%q", c.name, c.text, c.width, got, c.want)
+		}
+	}
+}
@@ -7,5 +7,5 @@ require golang.org/x/term v0.33.0
 require (
 	github.com/TwiN/go-away v1.6.16
 	golang.org/x/sys v0.34.0 // indirect
-	golang.org/x/text v0.27.0 // indirect
+	golang.org/x/text v0.27.0
 )
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+const (
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize     = 100 // the server won't send more than this at once
+)
+
+// state for paging back through the server's history with //history
+var (
+	pendingHistory      []*chatEntry // messages received so far for the page being fetched
+	historyCursor       int64        // oldest message ID fetched so far, 0 before the first page
+	historyExhausted    bool         // the server said there's nothing older
+	historyRequestSent  bool         // a page is on its way, don't ask for another yet
+	historyPageTotal    int          // messages in the open history view
+	historyToScrollback bool         // the page was asked for by scrolling up, so it goes into the chat instead of a view
+)
+
+// asks the server for up to limit messages older than anything we've seen
+func requestOlderHistory(conn net.Conn, limit int) {
+	screenMutex.Lock()
+	if historyRequestSent {
+		screenMutex.Unlock()
+		return
+	}
+	if historyExhausted {
+		screenMutex.Unlock()
+		noOlderHistory()
+		return
+	}
+
+	// start from the oldest message on screen, then keep going back from there
+	before := historyCursor
+	if before == 0 {
+		for _, entry := range entries {
+			if entry.id != 0 && (before == 0 || entry.id < before) {
+				before = entry.id
+			}
+		}
+	}
+	historyRequestSent = true
+	screenMutex.Unlock()
+
+	request := map[string]string{
+		"type":  "historyRequest",
+		"user":  config["username"].(string),
+		"limit": strconv.Itoa(limit),
+	}
+	if before != 0 {
+		request["before"] = strconv.FormatInt(before, 10)
+	}
+	sendJSON(conn, request)
+}
+
+// collects a message of the page being fetched
+func addHistoryMessage(jsonMsg map[string]string) {
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	pendingHistory = append(pendingHistory, entryFromMessage(jsonMsg))
+}
+
+// shows the fetched page once the server says it's complete
+func finishHistoryPage(jsonMsg map[string]string) {
+	screenMutex.Lock()
+	page := pendingHistory
+	pendingHistory = nil
+	historyRequestSent = false
+	toScrollback := historyToScrollback
+	historyToScrollback = false
+	historyExhausted = jsonMsg["hasMore"] != "true"
+	if oldest := parseMessageID(jsonMsg["oldest"]); oldest != 0 {
+		historyCursor = oldest
+	}
+	screenMutex.Unlock()
+
+	if len(page) == 0 {
+		if !toScrollback {
+			noOlderHistory()
+		}
+		return
+	}
+
+	var shown []*chatEntry
+	for _, entry := range page {
+		if !muteList[entry.user] {
+			shown = append(shown, entry)
+		}
+	}
+
+	screenMutex.Lock()
+	if toScrollback {
+		prependScrollback(shown)
+		screenMutex.Unlock()
+		redrawMessages()
+		return
+	}
+	// keep paging back in the same view, older pages go on top
+	if activeView != nil && activeView.name == "history" {
+		shown = append(shown, activeView.entries...)
+	} else {
+		historyPageTotal = 0
+	}
+	historyPageTotal += len(page)
+	activeView = &paneView{
+		name:    "history",
+		title:   historyTitle(),
+		entries: shown,
+		empty:   "Every message fetched so far is from a muted user.",
+	}
+	screenMutex.Unlock()
+	redrawMessages()
+}
+
+// title of the history view, caller must hold screenMutex
+func historyTitle() string {
+	more := "//history for older ones"
+	if historyExhausted {
+		more = "that's the beginning"
+	}
+	return fmt.Sprintf("--- history: %d older messages, %s ---", historyPageTotal, more)
+}
+
+// tells the user there's nothing further back, in the history view if it's open
+func noOlderHistory() {
+	screenMutex.Lock()
+	inView := activeView != nil && activeView.name == "history"
+	if inView {
+		activeView.title = historyTitle()
+	}
+	screenMutex.Unlock()
+	if !inView {
+		addServerMessage("No older messages.", "bold_yellow")
+	}
+	redrawMessages()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// servers give each username a secret token the first time it joins, and only hand over its
+// offline DMs, mentions and read markers to clients that send it back. they're kept per server
+// in identities.json in the data folder, key: server address, then username
+
+func identitiesPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "identities.json"), nil
+}
+
+func loadIdentities() map[string]map[string]string {
+	identities := make(map[string]map[string]string)
+	path, err := identitiesPath()
+	if err != nil {
+		return identities
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return identities
+	}
+	json.Unmarshal(data, &identities)
+	return identities
+}
+
+// the token we were given for username on server, empty if we don't have one
+func identityToken(server string, username string) string {
+	return loadIdentities()[server][username]
+}
+
+// keeps a token the server issued, readable only by us since it's as good as a password
+func saveIdentityToken(server string, username string, token string) error {
+	identities := loadIdentities()
+	if identities[server] == nil {
+		identities[server] = make(map[string]string)
+	}
+	identities[server][username] = token
+
+	path, err := identitiesPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(identities, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
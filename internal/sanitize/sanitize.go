@@ -0,0 +1,92 @@
+// Package sanitize strips terminal escape sequences and control characters from text other
+// people control, so a message can't clear the screen, move the cursor, recolor things or set
+// the window title. the client and the server both use it, the server so its console and clients
+// that show text as is are safe too
+package sanitize
+
+import "strings"
+
+// Text cleans message text, newlines are kept for multi-line messages and code blocks, tabs become spaces
+func Text(text string) string {
+	return clean(text, false)
+}
+
+// Line is like Text, for things that have to stay on one line like usernames
+func Line(text string) string {
+	return clean(text, true)
+}
+
+func clean(text string, oneLine bool) string {
+	runes := []rune(strings.ToValidUTF8(text, "�"))
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\n' && !oneLine:
+			b.WriteRune(r)
+		case r == '\t' && !oneLine:
+			b.WriteString("    ") // terminals disagree on where tab stops are
+		case r == '\n' || r == '\t':
+			b.WriteRune(' ')
+		case r == 0x1b:
+			i = skipEscape(runes, i)
+		case r == 0x9b: // 8-bit CSI
+			i = skipCSI(runes, i+1)
+		case r == 0x90 || r == 0x98 || r == 0x9d || r == 0x9e || r == 0x9f: // 8-bit DCS, SOS, OSC, PM, APC
+			i = skipControlString(runes, i+1)
+		case r < 0x20 || (r >= 0x7f && r <= 0x9f):
+			// other control characters: carriage returns, backspaces, bells...
+		case (r >= 0x202a && r <= 0x202e) || (r >= 0x2066 && r <= 0x2069):
+			// bidi overrides and isolates, which can make text read backwards
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// skips the escape sequence starting at runes[i], returns the index of its last rune
+func skipEscape(runes []rune, i int) int {
+	if i+1 >= len(runes) {
+		return i
+	}
+	switch runes[i+1] {
+	case '[':
+		return skipCSI(runes, i+2)
+	case ']', 'P', 'X', '^', '_': // OSC, DCS, SOS, PM, APC run until a terminator
+		return skipControlString(runes, i+2)
+	}
+	// everything else is intermediates then a final byte, like ESC c or ESC ( B
+	j := i + 1
+	for j < len(runes) && runes[j] >= 0x20 && runes[j] <= 0x2f {
+		j++
+	}
+	if j < len(runes) && runes[j] >= 0x30 && runes[j] <= 0x7e {
+		return j
+	}
+	return j - 1
+}
+
+// skips a CSI's parameters and final byte starting at runes[i], returns the index of its last rune
+func skipCSI(runes []rune, i int) int {
+	for i < len(runes) && runes[i] >= 0x20 && runes[i] <= 0x3f {
+		i++
+	}
+	if i < len(runes) && runes[i] >= 0x40 && runes[i] <= 0x7e {
+		return i
+	}
+	return i - 1
+}
+
+// skips a control string starting at runes[i] up to its BEL or ST, or the end of the text if it has none
+func skipControlString(runes []rune, i int) int {
+	for ; i < len(runes); i++ {
+		switch {
+		case runes[i] == 0x07 || runes[i] == 0x9c:
+			return i
+		case runes[i] == 0x1b && i+1 < len(runes) && runes[i+1] == '\\':
+			return i + 1
+		}
+	}
+	return len(runes) - 1
+}
@@ -0,0 +1,69 @@
+package sanitize
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// a hostile payload and what should be left of it
+type sanitizeCase struct {
+	Name  string `json:"name"`
+	Input string `json:"input"`
+	Text  string `json:"text"` // after Text
+	Line  string `json:"line"` // after Line
+}
+
+func loadSanitizeCases(t *testing.T) []sanitizeCase {
+	t.Helper()
+	data, err := os.ReadFile("testdata/hostile_payloads.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []sanitizeCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatal(err)
+	}
+	return cases
+}
+
+// nothing that could reach the terminal as a command should be left
+func checkNoControls(t *testing.T, name, got string, allowNewlines bool) {
+	t.Helper()
+	for _, r := range got {
+		if r == '\n' && allowNewlines {
+			continue
+		}
+		if r < 0x20 || (r >= 0x7f && r <= 0x9f) {
+			t.Errorf("%s: control character %U left in %q", name, r, got)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	for _, c := range loadSanitizeCases(t) {
+		got := Text(c.Input)
+		if got != c.Text {
+			t.Errorf("%s: Text(%q) = %q, want %q", c.Name, c.Input, got, c.Text)
+		}
+		checkNoControls(t, c.Name, got, true)
+	}
+}
+
+func TestLine(t *testing.T) {
+	for _, c := range loadSanitizeCases(t) {
+		got := Line(c.Input)
+		if got != c.Line {
+			t.Errorf("%s: Line(%q) = %q, want %q", c.Name, c.Input, got, c.Line)
+		}
+		checkNoControls(t, c.Name, got, false)
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	got := Text("ok\xff\x1b\xfe[2J")
+	checkNoControls(t, "invalid utf-8", got, false)
+	if got != "ok��[2J" {
+		t.Errorf("Text of invalid utf-8 = %q", got)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	minPaneWidth = 90 // narrower terminals get the simple layout even if panes are asked for
+	railWidth    = 18 // rooms and DMs on the left
+	sidebarWidth = 20 // online users on the right
+
+	latencyPingInterval = 15 * time.Second // how often the status bar measures latency
+	latencyTimeout      = 10 * time.Second // a ping unanswered for this long shows as no reply
+	statusPingID        = "status"         // sent with the status bar's pings and echoed back, so //ping's pong isn't taken for one
+)
+
+// connection and latency shown in the status bar, guarded by screenMutex
+var (
+	connected    bool          // the handshake is done
+	latency      time.Duration // round trip of the last answered ping, 0 before the first one
+	pingInFlight time.Time     // when the status bar's ping went out, zero when it's been answered
+)
+
+// people we've had DMs with, most recent last, and how many of theirs we haven't answered, guarded by screenMutex
+var (
+	dmPartners []string
+	unreadDMs  = make(map[string]int)
+)
+
+// which layout the config asks for, "simple" or "panes"
+func layoutName() string {
+	if name, ok := config["layout"].(string); ok {
+		return name
+	}
+	return "simple"
+}
+
+// whether the panes are drawn at this terminal width
+func panesActive(termWidth int) bool {
+	return layoutName() == "panes" && termWidth >= minPaneWidth && !plainMode
+}
+
+// the column messages start at and how wide they can be
+func messageArea() (int, int) {
+	width, _ := getTerminalSize()
+	if !panesActive(width) {
+		return 1, width
+	}
+	return railWidth + 2, width - railWidth - sidebarWidth - 2 // a separator on each side
+}
+
+// width messages are wrapped to
+func messageWidth() int {
+	_, width := messageArea()
+	return width
+}
+
+// cuts text with ansi color codes in it down to width columns, without an ellipsis
+func clipToWidth(text string, width int) string {
+	if displayWidth(text) <= width {
+		return text
+	}
+	var b strings.Builder
+	used := 0
+	inEscape := false
+	for _, r := range text {
+		switch {
+		case inEscape:
+			inEscape = r < 0x40 || r > 0x7e || r == '['
+		case r == 0x1b:
+			inEscape = true
+		default:
+			w := runeWidth(r)
+			if used+w > width {
+				return b.String() + ansiColors["reset"]
+			}
+			used += w
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// pads or clips text to exactly width columns
+func fitToWidth(text string, width int) string {
+	text = clipToWidth(text, width)
+	if pad := width - displayWidth(text); pad > 0 {
+		text += strings.Repeat(" ", pad)
+	}
+	return text
+}
+
+// remembers who a DM was with, caller must hold screenMutex
+func recordDirectMessage(entry *chatEntry) {
+	partner := entry.user
+	if entry.dmTo != "" {
+		partner = entry.dmTo
+	}
+	for i, p := range dmPartners {
+		if p == partner {
+			dmPartners = append(dmPartners[:i], dmPartners[i+1:]...)
+			break
+		}
+	}
+	dmPartners = append(dmPartners, partner)
+	if entry.dmTo != "" {
+		delete(unreadDMs, partner) // answering them counts as reading it
+	} else {
+		unreadDMs[partner]++
+	}
+}
+
+// the left rail, one string per row, caller must hold screenMutex
+func railLines() []string {
+	dim := "\033[2m"
+	reset := ansiColors["reset"]
+	room := "# " + serverName
+	if serverName == "" {
+		room = "# chat"
+	}
+	if activeView == nil {
+		room = themeColorCode() + room + reset
+	}
+	lines := []string{dim + "Rooms" + reset, room, "", dim + "Direct messages" + reset}
+	if len(dmPartners) == 0 {
+		lines = append(lines, dim+"none yet"+reset)
+	}
+	for i := len(dmPartners) - 1; i >= 0; i-- {
+		partner := dmPartners[i]
+		if n := unreadDMs[partner]; n > 0 {
+			count := fmt.Sprintf(" (%d)", n)
+			lines = append(lines, ansiColors["bold_yellow"]+"@ "+truncateToWidth(partner, railWidth-2-len(count))+count+reset)
+		} else {
+			lines = append(lines, "@ "+partner)
+		}
+	}
+	return lines
+}
+
+// the right sidebar, one string per row, caller must hold screenMutex
+func sidebarLines() []string {
+	dim := "\033[2m"
+	reset := ansiColors["reset"]
+	self, _ := config["username"].(string)
+
+	var lines []string
+	if rosterUsers != nil {
+		users := append([]string(nil), rosterUsers...)
+		sort.Strings(users)
+		lines = append(lines, dim+fmt.Sprintf("Online (%d)", len(users))+reset)
+		for _, user := range users {
+			_, typing := typingUsers[user]
+			switch {
+			case user == self:
+				lines = append(lines, user+dim+" (you)"+reset)
+			case typing:
+				lines = append(lines, user+dim+" …"+reset)
+			default:
+				lines = append(lines, user)
+			}
+		}
+		return lines
+	}
+	// the server doesn't say who's online, the best we can do is who's been talking
+	lines = append(lines, dim+"Recently seen"+reset)
+	for i := len(seenUsers) - 1; i >= 0; i-- {
+		lines = append(lines, seenUsers[i])
+	}
+	return lines
+}
+
+// draws the rail and sidebar next to the messages, caller must hold screenMutex
+func drawPanes(termWidth, height int) {
+	rail := railLines()
+	sidebar := sidebarLines()
+	sepColumn := termWidth - sidebarWidth
+	separator := "\033[2m│" + ansiColors["reset"]
+	for row := 2; row <= height-3; row++ {
+		i := row - 2
+		moveCursor(1, row)
+		line := ""
+		if i < len(rail) {
+			line = rail[i]
+		}
+		fmt.Print(fitToWidth(line, railWidth) + separator)
+
+		moveCursor(sepColumn, row)
+		line = ""
+		if i < len(sidebar) {
+			line = sidebar[i]
+		}
+		// leave the last column alone so the terminal doesn't wrap
+		fmt.Print(separator + fitToWidth(line, sidebarWidth-2))
+	}
+}
+
+// connection, latency and unread counts for the status bar, caller must hold screenMutex
+func statusBarText() string {
+	var parts []string
+	switch {
+	case !connected:
+		parts = append(parts, "connecting…")
+	case !pingInFlight.IsZero() && time.Since(pingInFlight) > latencyTimeout:
+		parts = append(parts, ansiColors["bold_red"]+"● no reply from server"+ansiColors["reset"]+"\033[7m")
+	default:
+		parts = append(parts, ansiColors["bold_green"]+"●"+ansiColors["reset"]+"\033[7m connected")
+	}
+	if latency > 0 {
+		parts = append(parts, fmt.Sprintf("%dms", latency.Milliseconds()))
+	}
+
+	dms := 0
+	for _, n := range unreadDMs {
+		dms += n
+	}
+	if dms > 0 {
+		parts = append(parts, fmt.Sprintf("%d unread DM(s)", dms))
+	}
+	if unseenMentions > 0 {
+		parts = append(parts, fmt.Sprintf("%d mention(s)", unseenMentions))
+	}
+	if scrollNewBelow > 0 {
+		parts = append(parts, fmt.Sprintf("%d new below", scrollNewBelow))
+	}
+	return " " + strings.Join(parts, " · ")
+}
+
+// draws the status bar on the bottom row, only in the pane layout, caller must hold screenMutex
+func drawStatusBar() {
+	width, height := getTerminalSize()
+	if !panesActive(width) {
+		return
+	}
+	moveCursor(1, height)
+	fmt.Print("\033[7m" + fitToWidth(statusBarText(), width-1) + ansiColors["reset"]) // reversed, like most status bars
+}
+
+// the server answered a ping, returns false if it was one //ping sent so it gets shown in the chat.
+// servers that don't echo the id back get the pong counted as the status bar's unless //ping is waiting
+func recordPong(id string) bool {
+	if id != statusPingID && (id != "" || !lastPingTimestamp.IsZero()) {
+		return false
+	}
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	if pingInFlight.IsZero() {
+		return false
+	}
+	latency = time.Since(pingInFlight)
+	pingInFlight = time.Time{}
+	drawStatusLine()
+	drawInputLine()
+	return true
+}
+
+// pings the server now and then while the panes are shown, so the status bar has a latency to show
+func watchLatency(conn net.Conn) {
+	for range time.Tick(latencyPingInterval) {
+		width, _ := getTerminalSize()
+		if !panesActive(width) {
+			continue
+		}
+		screenMutex.Lock()
+		send := connected && pingInFlight.IsZero()
+		if send {
+			pingInFlight = time.Now()
+		}
+		// redraw so a missing reply shows up
+		drawStatusLine()
+		drawInputLine()
+		screenMutex.Unlock()
+
+		if send {
+			sendJSON(conn, map[string]string{
+				"type": "ping",
+				"user": config["username"].(string),
+				"id":   statusPingID,
+			})
+		}
+	}
+}
+
+// switches between the simple and pane layouts for this session
+func setLayout(name string) {
+	screenMutex.Lock()
+	config["layout"] = name
+	clearScreen() // the panes and status bar would stay otherwise
+	screenMutex.Unlock()
+	redrawMessages()
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -11,11 +12,17 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 	"unsafe"
 
+	"github.com/BananaJeans/tchat/internal/sanitize"
 	"golang.org/x/term"
 )
 
@@ -55,6 +62,12 @@ var ansiColors = map[string]string{
 
 var messageCharLimit = 180 // max characters per message
 
+// optional protocol features this client supports, sent to the server during the handshake
+var clientCapabilities = []string{"typing", "threads", "edit", "reactions", "history", "search", "dm", "unread", "purge", "roster", "identity"}
+
+// capability list the server advertised in its handshake
+var serverCapabilities string
+
 // clears the screen based on os
 func clearScreen() {
 	var cmd *exec.Cmd
@@ -79,13 +92,20 @@ func moveCursor(x, y int) {
 	fmt.Printf("\033[%d;%dH", y, x)
 }
 
-var messages []string
+// entries in the message pane, oldest first
+var entries []*chatEntry
 var maxMessages int
 
-// initializes the chat area based on terminal size
+// guards entries and everything drawn to the terminal, since both the input and the reader goroutine draw
+var screenMutex sync.Mutex
+
+// initializes the chat area based on terminal size, again whenever it's resized
 func initChatArea() {
 	_, height := getTerminalSize()
 	maxMessages = height - 4 // reserve space for header and input
+	if maxMessages < 1 {
+		maxMessages = 1
+	}
 }
 
 func canSendMessage() bool {
@@ -120,6 +140,22 @@ func sendMessage(conn net.Conn, user string, msg string, color string) {
 		"type":    "message",
 		"color":   color,
 	}
+	sendJSON(conn, jsonMsg)
+}
+
+// sends a reply to the message with the given ID
+func sendReply(conn net.Conn, replyTo int64, msg string) {
+	sendJSON(conn, map[string]string{
+		"user":    config["username"].(string),
+		"message": msg,
+		"type":    "message",
+		"color":   validateColorName(config["color"].(string)),
+		"replyTo": strconv.FormatInt(replyTo, 10),
+	})
+}
+
+// marshals a message and sends it to the server, reporting failures in the chat
+func sendJSON(conn net.Conn, jsonMsg map[string]string) {
 	jsonData, err := json.Marshal(jsonMsg)
 	if err != nil {
 		addServerMessage("Error marshaling message: "+err.Error(), "bold_red")
@@ -132,105 +168,312 @@ func sendMessage(conn net.Conn, user string, msg string, color string) {
 	}
 }
 func validateAnsi(color string) string {
-	if ansiColors[color] != "" {
-		return ansiColors[color]
+	if code := colorCode(color); code != "" {
+		return code
 	}
 	fmt.Println("Invalid color specified, using default (blue)")
 	return ansiColors["blue"] // default to blue if invalid
 }
 
 func validateColorName(color string) string {
-	if _, ok := ansiColors[color]; ok {
+	if isValidColor(color) {
 		return color
 	}
 	return "blue"
 }
 
-// wraps text on screen
-func wrapText(text string, width int) []string {
-	var lines []string
-	for len(text) > width {
-		lines = append(lines, text[:width])
-		text = text[width:]
-	}
-	if len(text) > 0 {
-		lines = append(lines, text)
-	}
-	return lines
-}
-
 // strips ansi codes from a string
 func stripAnsiCodes(str string) string {
 	re := regexp.MustCompile(`\x1b\[[0-9;]*m`)
 	return re.ReplaceAllString(str, "")
 }
 
+// something shown in the message pane, either a chat message or a notice
+type chatEntry struct {
+	id           int64               // server-assigned message ID, 0 for notices
+	user         string              // who sent it, empty for notices
+	text         string              // the message itself
+	color        string              // color name of the user, or the ansi code for a notice
+	notice       bool                // server or client notice rather than a user message
+	timestamp    time.Time           // when the server accepted the message, zero if unknown
+	replyTo      int64               // ID of the message this one replies to, 0 if none
+	replyUser    string              // author of the message replied to
+	replySnippet string              // start of the message replied to
+	edited       bool                // whether the author edited it after sending
+	deleted      bool                // whether it was deleted by its author or a moderator
+	reactions    map[string][]string // key: emoji, value: users who reacted with it
+	dm           bool                // a direct message rather than one to the whole chat
+	dmTo         string              // who we sent a DM to, empty for DMs sent to us
+	printed      bool                // already printed in plain mode
+}
+
+// builds an entry from a chat message sent by the server
+func entryFromMessage(jsonMsg map[string]string) *chatEntry {
+	entry := &chatEntry{
+		id:           parseMessageID(jsonMsg["id"]),
+		user:         jsonMsg["user"],
+		text:         jsonMsg["message"],
+		color:        jsonMsg["color"],
+		replyTo:      parseMessageID(jsonMsg["replyTo"]),
+		replyUser:    jsonMsg["replyUser"],
+		replySnippet: jsonMsg["replySnippet"],
+		edited:       jsonMsg["edited"] == "true",
+		reactions:    parseReactions(jsonMsg["reactions"]),
+	}
+	if ts, err := time.Parse(time.RFC3339, jsonMsg["timestamp"]); err == nil {
+		entry.timestamp = ts
+	}
+	return entry
+}
+
+// parses the reactions field the server sends, which is a json object of emoji to users
+func parseReactions(field string) map[string][]string {
+	if field == "" {
+		return nil
+	}
+	var reactions map[string][]string
+	if err := json.Unmarshal([]byte(field), &reactions); err != nil {
+		return nil
+	}
+	// these come from other people too
+	clean := make(map[string][]string, len(reactions))
+	for emoji, users := range reactions {
+		clean[sanitize.Line(emoji)] = sanitizeList(users)
+	}
+	return clean
+}
+
+// builds the compact "👍 2  🎉 1" line shown under a message, most popular first
+func reactionSummary(reactions map[string][]string) string {
+	emojis := make([]string, 0, len(reactions))
+	for emoji := range reactions {
+		emojis = append(emojis, emoji)
+	}
+	sort.Slice(emojis, func(i, j int) bool {
+		if len(reactions[emojis[i]]) != len(reactions[emojis[j]]) {
+			return len(reactions[emojis[i]]) > len(reactions[emojis[j]])
+		}
+		return emojis[i] < emojis[j]
+	})
+
+	username, _ := config["username"].(string)
+	parts := make([]string, 0, len(emojis))
+	for _, emoji := range emojis {
+		part := fmt.Sprintf("%s %d", emoji, len(reactions[emoji]))
+		// make your own reactions stand out
+		for _, user := range reactions[emoji] {
+			if user == username {
+				part = "\033[1m" + part + ansiColors["reset"]
+				break
+			}
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "  ")
+}
+
+// parses a message ID sent by the server, 0 if missing or invalid
+func parseMessageID(s string) int64 {
+	id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || id < 1 {
+		return 0
+	}
+	return id
+}
+
+// shortens text to at most n runes, marking the cut with an ellipsis
+func truncateText(text string, n int) string {
+	runes := []rune(text)
+	if n < 1 {
+		return ""
+	}
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// adds an entry to the message pane, dropping the oldest once the scrollback is full
+func addEntry(entry *chatEntry) {
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	entries = append(entries, entry)
+	keepScrollPosition(entry)
+
+	if len(entries) > maxScrollback {
+		entries = entries[len(entries)-maxScrollback:]
+	}
+}
+
 // for messages sent by other users
-func addMessage(user string, msg string, color string) {
+func addMessage(jsonMsg map[string]string) {
+	entry := entryFromMessage(jsonMsg)
+	if entry.timestamp.IsZero() {
+		entry.timestamp = time.Now() // older servers don't stamp messages
+	}
+	addEntry(entry)
+	screenMutex.Lock()
+	recordSessionMessage(entry)
+	recordSeenUser(entry.user)
+	screenMutex.Unlock()
+	if entry.user != config["username"].(string) && mentionsMe(entry.text) {
+		recordMention(entry)
+	}
+}
+
+// for messages sent from the server
+func addServerMessage(msg string, color ...string) {
+	// color the server message, the theme's server color unless told otherwise
+	colorCode := serverColorCode()
+	if len(color) > 0 && ansiColors[color[0]] != "" {
+		colorCode = ansiColors[color[0]]
+	}
+	addEntry(&chatEntry{text: msg, color: colorCode, notice: true, timestamp: time.Now()})
+}
+
+// turns an entry into the lines it takes up on a screen width columns wide
+func renderEntry(entry *chatEntry, width int) []string {
+	if width < 10 {
+		width = 10 // don't loop forever on absurdly small terminals
+	}
+
+	var lines []string
+	if entry.notice {
+		for _, line := range wrapText(entry.text, width) {
+			lines = append(lines, entry.color+line+ansiColors["reset"])
+		}
+		return lines
+	}
+
+	// quote the message this one replies to above it
+	if entry.replyTo != 0 {
+		quote := fmt.Sprintf("  ↳ #%d @%s: %s", entry.replyTo, entry.replyUser, entry.replySnippet)
+		lines = append(lines, "\033[2m"+truncateToWidth(quote, width)+ansiColors["reset"])
+	}
+
+	// show the ID so people know what to //reply to
+	idPrefix := ""
+	prefixStyle := "\033[2m" // dim
+	if entry.id != 0 {
+		idPrefix = fmt.Sprintf("#%d ", entry.id)
+	}
+	if entry.dm {
+		// DMs have no ID, make them stand out instead
+		idPrefix = "DM "
+		if entry.dmTo != "" {
+			idPrefix = "DM to @" + entry.dmTo + " from "
+		}
+		prefixStyle = dmColorCode()
+	}
+
 	// add @ prefix
-	displayUser := user
-	if user != "" && user[0] != '@' {
-		displayUser = "@" + user
+	displayUser := entry.user
+	if displayUser != "" && displayUser[0] != '@' {
+		displayUser = "@" + displayUser
 	}
 
 	// validate color
-	if color == "" || ansiColors[color] == "" {
+	color := entry.color
+	if !isValidColor(color) {
 		color = "blue" // default to blue if color is invalid
 	}
-	displayUser = validateAnsi(color) + displayUser + ansiColors["reset"] // wrap username
-
-	// color username
-	displayUser = "\033[34m" + displayUser + "\033[0m" // blue color
-
-	width, _ := getTerminalSize()
+	coloredUser := validateAnsi(color) + displayUser + ansiColors["reset"] // wrap username
 
-	usernameWidth := len(stripAnsiCodes(displayUser))
+	usernameWidth := displayWidth(idPrefix) + displayWidth(displayUser)
 
-	wrappedLines := wrapText(msg, width-usernameWidth) // indent for username
+	if entry.deleted {
+		return append(lines, fmt.Sprintf("%s%s\033[0m%s: \033[2m(message deleted)\033[0m", prefixStyle, idPrefix, coloredUser))
+	}
 
-	// if the message is too long, wrap it
+	// if the message is too long, wrap it, indented past the username
+	textWidth := width - usernameWidth - 2
+	indent := strings.Repeat(" ", usernameWidth)
+	wrappedLines := renderMarkup(entry.text, textWidth)
 	for i, line := range wrappedLines {
 		if i == 0 {
-			messages = append(messages, fmt.Sprintf("%s: %s", displayUser, line))
+			lines = append(lines, fmt.Sprintf("%s%s\033[0m%s: %s", prefixStyle, idPrefix, coloredUser, line))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s  %s", indent, line))
+		}
+	}
+
+	// mark edited messages, on the last line if there's room for it
+	if entry.edited {
+		const editedMarker = " \033[2m(edited)\033[0m"
+		if len(wrappedLines) > 0 && displayWidth(wrappedLines[len(wrappedLines)-1])+len(" (edited)") <= textWidth {
+			lines[len(lines)-1] += editedMarker
 		} else {
-			messages = append(messages, fmt.Sprintf("%s  %s", strings.Repeat(" ", usernameWidth), line))
+			lines = append(lines, indent+" "+editedMarker)
 		}
 	}
 
-	if len(messages) > maxMessages {
-		messages = messages[len(messages)-maxMessages:]
+	// reactions go on their own line under the message
+	if len(entry.reactions) > 0 {
+		lines = append(lines, indent+"  "+reactionSummary(entry.reactions))
 	}
+	return lines
 }
 
-// for messages sent from the server
-func addServerMessage(msg string, color ...string) {
-	// color the server message, color should always be bold
-	themeColor := config["themeColor"].(string)
-	if len(color) > 0 && ansiColors[color[0]] != "" {
-		msg = ansiColors[color[0]] + msg + ansiColors["reset"]
-	} else {
-		// handle magenta specially since there's no "bold_magenta", use "bold_purple"
-		if themeColor == "magenta" {
-			msg = ansiColors["bold_purple"] + msg + ansiColors["reset"]
-		} else {
-			if strings.HasPrefix(themeColor, "bold_") {
-				msg = ansiColors[themeColor] + msg + ansiColors["reset"]
-			} else {
-				msg = ansiColors["bold_"+themeColor] + msg + ansiColors["reset"]
+// applies an edit or delete from the server to every copy of the message on screen and in the session
+func updateEntries(id int64, update func(entry *chatEntry)) {
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	updated := make(map[*chatEntry]bool)
+	for _, list := range [][]*chatEntry{entries, sessionMessages} {
+		for _, entry := range list {
+			if entry.id == id && !entry.notice && !updated[entry] {
+				update(entry)
+				updated[entry] = true
 			}
-		} // default to themeColor set in config
+		}
 	}
-	messages = append(messages, msg)
+}
 
-	// keep only the messages that fit on screen
-	if len(messages) > maxMessages {
-		messages = messages[1:] // remove oldest message
+// an alternate list shown in the message pane instead of the chat, like //mentions
+type paneView struct {
+	name    string       // which command opened it
+	title   string       // shown above the entries
+	entries []*chatEntry // what to show
+	empty   string       // shown when there are no entries
+
+	showTimes bool // show times even if timestampFormat hides them, for results from all over the history
+}
+
+// view currently covering the chat, nil when the chat itself is shown
+var activeView *paneView
+
+// renders every entry, oldest first
+func renderEntries(width int) []string {
+	var lines []string
+	if activeView != nil {
+		lines = append(lines, themeColorCode()+activeView.title+ansiColors["reset"])
+		if len(activeView.entries) == 0 {
+			lines = append(lines, "\033[2m"+activeView.empty+ansiColors["reset"])
+		}
+		layout := timestampFormat()
+		if layout == "" && activeView.showTimes {
+			layout = fallbackTimestampFormat
+		}
+		lines = append(lines, renderTimedEntries(activeView.entries, width, layout, 0)...)
+		return append(lines, "\033[2m(//back to return to the chat)"+ansiColors["reset"])
 	}
+
+	return renderTimedEntries(entries, width, timestampFormat(), unreadAfter)
 }
 
 // redraws message area in terminal, should be called every time something changes
 func redrawMessages() {
-	_, height := getTerminalSize()
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+
+	// nothing gets redrawn in plain mode, new lines are just printed
+	if plainMode {
+		printNewEntries()
+		return
+	}
+
+	termWidth, height := getTerminalSize()
+	column, width := messageArea()
 
 	// Clear the message area (not the whole screen)
 	for i := 0; i < height-2; i++ {
@@ -263,22 +506,8 @@ func redrawMessages() {
 		}
 		fmt.Printf("%s--- tchat (unconfigured) ---%s\n", colorCode, ansiColors["reset"])
 	} else {
-		themeColor := config["themeColor"].(string)
-		boldColor := themeColor
-		if !strings.HasPrefix(themeColor, "bold_") {
-			// handle magenta specially since there's no "bold_magenta", use "bold_purple"
-			if themeColor == "magenta" {
-				boldColor = "bold_purple"
-			} else {
-				boldColor = "bold_" + themeColor
-			}
-		}
-		colorCode, ok := ansiColors[boldColor]
-		if !ok {
-			colorCode = ansiColors["bold_blue"]
-		}
 		fmt.Printf("%s--- %s on %s:%d as %s ---%s\n",
-			colorCode,
+			themeColorCode(),
 			serverName,
 			config["server"],
 			int(config["port"].(float64)),
@@ -286,6 +515,9 @@ func redrawMessages() {
 			ansiColors["reset"])
 	}
 
+	// only some of the lines fit on screen, the newest unless we're scrolled up
+	messages := visibleLines(renderEntries(width))
+
 	// calculate starting line for messages
 	startLine := height - 2 - len(messages)
 	if startLine < 2 {
@@ -294,14 +526,18 @@ func redrawMessages() {
 
 	// draw messages from calculated starting position
 	for i, msg := range messages {
-		moveCursor(1, startLine+i)
-		fmt.Println(msg)
+		moveCursor(column, startLine+i)
+		fmt.Println(clipToWidth(msg, width))
 	}
 
-	// clear line -2
-	moveCursor(1, height-2)
-	clearLine()
+	// rooms and DMs on the left, who's online on the right
+	if panesActive(termWidth) {
+		drawPanes(termWidth, height)
+	}
 
+	// status line (typing indicator) and the input line go below the messages
+	drawStatusLine()
+	drawInputLine()
 }
 
 // clears the current cursor line in the terminal
@@ -319,12 +555,18 @@ func loadConfig() map[string]interface{} {
 			fmt.Printf("Config file '%s' not found, creating one!\n", configFile)
 			// if doesnt exist, create default config file
 			defaultConfig := map[string]interface{}{
-				"server":         "37.27.51.34", // default server hosted on Nest
-				"serverPassword": "",     // used if the server has PasswordProtected enabled
-				"port":           9076.0, // make sure its float64
-				"username":       "user",
-				"color":          "blue", // has to be an ansi color, otherwise server rejects + goes to default (blue)
-				"themeColor":     "blue", // theme used in banner and default server messages
+				"server":              "37.27.51.34", // default server hosted on Nest
+				"serverPassword":      "",            // used if the server has PasswordProtected enabled
+				"port":                9076.0,        // make sure its float64
+				"username":            "user",
+				"color":               "blue",                 // has to be an ansi color, otherwise server rejects + goes to default (blue)
+				"themeColor":          "blue",                 // theme used in banner and default server messages
+				"typingIndicators":    true,                   // whether to tell others when you're typing
+				"mentionNotification": "bell",                 // how to tell you about @mentions: "bell", "osc9" or "none"
+				"localTranscripts":    false,                  // whether to keep a copy of every message in your data folder, for `tchat log`
+				"timestampFormat":     defaultTimestampFormat, // how message times are shown, as a Go time layout, empty hides them
+				"layout":              "simple",               // "panes" adds a rooms/DMs rail, an online list and a status bar on wide terminals
+				"theme":               "default",              // a theme from the themes folder, "default" follows themeColor
 			}
 			file, err := os.Create(configFile)
 			if err != nil {
@@ -368,6 +610,24 @@ func loadConfig() map[string]interface{} {
 	return config
 }
 
+// reads an optional boolean from the config, falling back to def if it's missing
+func configBool(key string, def bool) bool {
+	if val, ok := config[key].(bool); ok {
+		return val
+	}
+	return def
+}
+
+// checks whether a comma separated capability list contains capability
+func hasCapability(list string, capability string) bool {
+	for _, c := range strings.Split(list, ",") {
+		if strings.TrimSpace(c) == capability {
+			return true
+		}
+	}
+	return false
+}
+
 func getAnsiColorNames() []string {
 	colorNames := make([]string, 0, len(ansiColors))
 	for name := range ansiColors {
@@ -410,7 +670,7 @@ func validateConfig(config map[string]interface{}) (string, bool) {
 		isConfigOk = false
 	} else {
 		username := config["username"].(string)
-		if len(username) < 3 || len(username) > 20 {
+		if n := utf8.RuneCountInString(username); n < 3 || n > 20 {
 			configValidateResponse += "username must be between 3 and 20 characters long\n"
 			isConfigOk = false
 		}
@@ -422,8 +682,8 @@ func validateConfig(config map[string]interface{}) (string, bool) {
 		isConfigOk = false
 	} else {
 		color := config["color"].(string)
-		if _, exists := ansiColors[color]; !exists {
-			configValidateResponse += fmt.Sprintf("color must be one of: %s\n", strings.Join(getAnsiColorNames(), ", "))
+		if !isValidColor(color) {
+			configValidateResponse += fmt.Sprintf("color must be one of: %s, a 256 color number (0-255) or #rrggbb\n", strings.Join(getAnsiColorNames(), ", "))
 			isConfigOk = false
 		}
 	}
@@ -434,8 +694,70 @@ func validateConfig(config map[string]interface{}) (string, bool) {
 		isConfigOk = false
 	} else {
 		themeColor := config["themeColor"].(string)
-		if _, exists := ansiColors[themeColor]; !exists {
-			configValidateResponse += fmt.Sprintf("themeColor must be one of: %s\n", strings.Join(getAnsiColorNames(), ", "))
+		if !isValidColor(themeColor) {
+			configValidateResponse += fmt.Sprintf("themeColor must be one of: %s, a 256 color number (0-255) or #rrggbb\n", strings.Join(getAnsiColorNames(), ", "))
+			isConfigOk = false
+		}
+	}
+
+	// theme check, optional for older configs
+	if val, exists := config["theme"]; exists {
+		if name, ok := val.(string); !ok {
+			configValidateResponse += "theme must be a string\n"
+			isConfigOk = false
+		} else if _, err := loadTheme(name); err != nil {
+			configValidateResponse += err.Error() + "\n"
+			isConfigOk = false
+		}
+	}
+
+	// moderatorPassword check, optional for older configs
+	if val, exists := config["moderatorPassword"]; exists {
+		if _, ok := val.(string); !ok {
+			configValidateResponse += "moderatorPassword must be a string\n"
+			isConfigOk = false
+		}
+	}
+
+	// mentionNotification check, optional for older configs
+	if val, exists := config["mentionNotification"]; exists {
+		if mode, ok := val.(string); !ok || (mode != "bell" && mode != "osc9" && mode != "none") {
+			configValidateResponse += "mentionNotification must be one of: bell, osc9, none\n"
+			isConfigOk = false
+		}
+	}
+
+	// localTranscripts check, optional for older configs
+	if val, exists := config["localTranscripts"]; exists {
+		if _, ok := val.(bool); !ok {
+			configValidateResponse += "localTranscripts must be a boolean value\n"
+			isConfigOk = false
+		}
+	}
+
+	// timestampFormat check, optional for older configs
+	if val, exists := config["timestampFormat"]; exists {
+		if format, ok := val.(string); !ok {
+			configValidateResponse += "timestampFormat must be a string\n"
+			isConfigOk = false
+		} else if len(format) > 30 {
+			configValidateResponse += "timestampFormat must be under 30 characters\n"
+			isConfigOk = false
+		}
+	}
+
+	// layout check, optional for older configs
+	if val, exists := config["layout"]; exists {
+		if layout, ok := val.(string); !ok || (layout != "simple" && layout != "panes") {
+			configValidateResponse += "layout must be one of: simple, panes\n"
+			isConfigOk = false
+		}
+	}
+
+	// typingIndicators check, optional for older configs
+	if val, exists := config["typingIndicators"]; exists {
+		if _, ok := val.(bool); !ok {
+			configValidateResponse += "typingIndicators must be a boolean value\n"
 			isConfigOk = false
 		}
 	}
@@ -443,9 +765,52 @@ func validateConfig(config map[string]interface{}) (string, bool) {
 	return configValidateResponse, isConfigOk
 }
 
+// thread messages received so far for a pending //thread request
+var pendingThread []*chatEntry
+
+// shows a thread fetched with //thread in the message pane
+func showThread(rootID string) {
+	screenMutex.Lock()
+	thread := pendingThread
+	pendingThread = nil
+	screenMutex.Unlock()
+
+	if len(thread) == 0 {
+		addServerMessage(fmt.Sprintf("Thread #%s not found.", rootID), "bold_red")
+		redrawMessages()
+		return
+	}
+
+	addServerMessage(fmt.Sprintf("--- thread #%s, %d messages ---", rootID, len(thread)))
+	for _, entry := range thread {
+		if !muteList[entry.user] {
+			addEntry(entry)
+		}
+	}
+	addServerMessage("--- end of thread ---")
+	redrawMessages()
+}
+
+// returns what's left of a command line after skipping its first n words
+func commandText(cmdLine string, n int) string {
+	rest := strings.TrimSpace(cmdLine)
+	for i := 0; i < n; i++ {
+		idx := strings.IndexAny(rest, " \t")
+		if idx < 0 {
+			return ""
+		}
+		rest = strings.TrimSpace(rest[idx:])
+	}
+	return rest
+}
+
 func clearMessages() {
-	// clear the messages slice
-	messages = []string{}
+	// clear the entries slice
+	screenMutex.Lock()
+	entries = nil
+	unreadAfter = 0
+	scrollOffset = 0
+	screenMutex.Unlock()
 	redrawMessages()
 }
 
@@ -519,8 +884,21 @@ func formatAddress(addr string, port int) string {
 
 // main process
 func main() {
+	applyNoColor()
+
+	// `tchat log` reads saved transcripts without connecting anywhere
+	if len(os.Args) > 1 && os.Args[1] == "log" {
+		// just for the theme and our username, a missing or broken config is fine here
+		config = map[string]interface{}{}
+		if data, err := os.ReadFile("./tchatconfig.json"); err == nil {
+			json.Unmarshal(data, &config)
+		}
+		os.Exit(runLogViewer(os.Args[2:]))
+	}
+
 	// set window title
 	SetProcessName("tchat")
+	plainMode = wantPlainMode(os.Args[1:])
 
 	// load up config
 	config = loadConfig()
@@ -533,78 +911,90 @@ func main() {
 		os.Exit(1)
 	}
 
+	// already checked by validateConfig
+	if name, ok := config["theme"].(string); ok {
+		activeTheme, _ = loadTheme(name)
+	}
+
 	fmt.Println("Logged in as", config["username"])
 
+	address := formatAddress(fmt.Sprintf("%v", config["server"]), int(config["port"].(float64)))
+
+	// keep a local copy of everything we see on this server
+	if configBool("localTranscripts", false) {
+		if err := openTranscript(address); err != nil {
+			fmt.Println("Error opening transcript, not keeping one:", err)
+		}
+	}
+
 	// connect to the TCP chat server
-	conn, err := net.Dial("tcp", formatAddress(fmt.Sprintf("%v", config["server"]), int(config["port"].(float64))))
+	conn, err := net.Dial("tcp", address)
 	if err != nil {
 		fmt.Println("Error connecting to server:", err)
 		os.Exit(1)
 	}
 	defer conn.Close()
+	serverConn = conn
 
 	// setup goroutine to handle incoming data
 	go func() {
-		buffer := make([]byte, 1024)
+		// the server can send several messages back to back, so decode them as a stream
+		decoder := json.NewDecoder(conn)
 		for {
-			n, err := conn.Read(buffer)
-			if err != nil {
-				if err == io.EOF {
-					fmt.Println("Server disconnected.")
-					os.Exit(1)
-				} else {
-					fmt.Println("Error reading from server:", err)
-					os.Exit(1)
-				}
-				return
-			}
-			if n == 0 {
-				fmt.Println("Server disconnected")
-				os.Exit(1)
-			}
-
 			// parse the incoming message
 			var jsonMsg map[string]string
-			if err := json.Unmarshal(buffer[:n], &jsonMsg); err != nil {
-				fmt.Println("Error parsing JSON:", err)
-				return
+			if err := decoder.Decode(&jsonMsg); err != nil {
+				if err == io.EOF {
+					exitClient(1, "Server disconnected.")
+				}
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &typeErr) {
+					continue // not something we understand, skip it
+				}
+				exitClient(1, fmt.Sprint("Error reading from server: ", err))
 			}
+			// nothing other people send gets to talk to the terminal
+			sanitizeMessage(jsonMsg)
 
 			switch jsonMsg["type"] {
 			case "message":
+				logTranscript(jsonMsg)
 				// check if user or server
 				if jsonMsg["user"] == "server" {
 					addServerMessage(jsonMsg["message"])
+					screenMutex.Lock()
+					recordSessionMessage(serverEntryFromMessage(jsonMsg))
+					screenMutex.Unlock()
 				} else {
 					// check if user is muted first
 					if muteList[jsonMsg["user"]] {
 						continue
 					} else {
+						// a message means they're done typing
+						setTyping(jsonMsg["user"], "stop")
 						// add user message
-						addMessage(jsonMsg["user"], jsonMsg["message"], jsonMsg["color"])
+						addMessage(jsonMsg)
 					}
 				}
 
 				redrawMessages()
-
-				// restore cursor to input line
-				_, currentHeight := getTerminalSize()
-				moveCursor(1, currentHeight-1)
-				fmt.Print("Message: ")
+			case "typing":
+				setTyping(jsonMsg["user"], jsonMsg["state"])
 			case "pong":
 				// handle ping response
+				if recordPong(jsonMsg["id"]) {
+					continue // one the status bar sent
+				}
 				if lastPingTimestamp.IsZero() {
 					continue
 				} else {
 					pingDifference := time.Since(lastPingTimestamp)
+					screenMutex.Lock()
+					latency = pingDifference
+					screenMutex.Unlock()
 					addServerMessage(fmt.Sprint("Pong! Latency: ", pingDifference.Milliseconds(), "ms"), "bold_green")
 					lastPingTimestamp = time.Time{} // unset after pong
 					redrawMessages()
-
-					// restore cursor to input line
-					_, currentHeight := getTerminalSize()
-					moveCursor(1, currentHeight-1)
-					fmt.Print("Message: ")
 				}
 			case "handshake":
 				// handle handshake
@@ -629,6 +1019,19 @@ func main() {
 					}
 				}
 
+				serverCapabilities = jsonMsg["capabilities"]
+				screenMutex.Lock()
+				connected = true
+				screenMutex.Unlock()
+
+				// only send typing events if the server knows what to do with them
+				if hasCapability(serverCapabilities, "typing") && configBool("typingIndicators", true) {
+					typing.mu.Lock()
+					typing.conn = conn
+					typing.enabled = true
+					typing.mu.Unlock()
+				}
+
 				var handshakeResp = map[string]string{}
 
 				if isPasswordProtected {
@@ -637,15 +1040,27 @@ func main() {
 						"user":           config["username"].(string),
 						"message":        "OK",
 						"serverPassword": config["serverPassword"].(string),
+						"capabilities":   strings.Join(clientCapabilities, ","),
 					}
 				} else {
 					handshakeResp = map[string]string{
-						"type":    "handshake",
-						"user":    config["username"].(string),
-						"message": "OK",
+						"type":         "handshake",
+						"user":         config["username"].(string),
+						"message":      "OK",
+						"capabilities": strings.Join(clientCapabilities, ","),
 					}
 				}
 
+				// proves the username is ours, so the server hands over our offline DMs and read markers
+				if token := identityToken(address, config["username"].(string)); token != "" {
+					handshakeResp["identityToken"] = token
+				}
+
+				// moderators can delete anyone's messages
+				if modPassword, ok := config["moderatorPassword"].(string); ok && modPassword != "" {
+					handshakeResp["moderatorPassword"] = modPassword
+				}
+
 				jsonResp, err := json.Marshal(handshakeResp)
 				if err != nil {
 					fmt.Println("Error marshaling handshake response:", err)
@@ -656,12 +1071,15 @@ func main() {
 					fmt.Println("Error sending handshake response:", err)
 					return
 				}
+			case "identity":
+				// the server registered our username and wants this back next time
+				if err := saveIdentityToken(address, config["username"].(string), jsonMsg["token"]); err != nil {
+					addServerMessage(fmt.Sprint("Couldn't save the identity token, DMs won't be kept for you next time: ", err), "bold_red")
+					redrawMessages()
+				}
 			case "alreadyInUse":
 				if jsonMsg["user"] == "server" {
-					fmt.Println("Username already in use, please choose a different one.")
-					os.Stdout.Sync() // flush stdout
-					os.Exit(1)
-					break
+					exitClient(1, "Username already in use, please choose a different one.")
 				}
 				addServerMessage(jsonMsg["message"])
 			case "clearChat":
@@ -669,6 +1087,62 @@ func main() {
 				clearMessages()
 				addServerMessage("Chat history has been cleared by the server.", "bold_yellow")
 				redrawMessages()
+			case "edit":
+				logTranscript(jsonMsg)
+				updateEntries(parseMessageID(jsonMsg["id"]), func(entry *chatEntry) {
+					entry.text = jsonMsg["message"]
+					entry.edited = true
+				})
+				announce("%s was edited: %s", describeMessage(parseMessageID(jsonMsg["id"])), jsonMsg["message"])
+				redrawMessages()
+			case "delete":
+				logTranscript(jsonMsg)
+				announce("%s was deleted.", describeMessage(parseMessageID(jsonMsg["id"])))
+				updateEntries(parseMessageID(jsonMsg["id"]), func(entry *chatEntry) {
+					entry.text = ""
+					entry.deleted = true
+				})
+				redrawMessages()
+			case "reaction":
+				logTranscript(jsonMsg)
+				reactions := parseReactions(jsonMsg["reactions"])
+				updateEntries(parseMessageID(jsonMsg["id"]), func(entry *chatEntry) {
+					entry.reactions = reactions
+				})
+				if slices.Contains(reactions[jsonMsg["reaction"]], jsonMsg["user"]) {
+					announce("@%s reacted %s to #%s.", jsonMsg["user"], jsonMsg["reaction"], jsonMsg["id"])
+				} else {
+					announce("@%s took back their %s on #%s.", jsonMsg["user"], jsonMsg["reaction"], jsonMsg["id"])
+				}
+				redrawMessages()
+			case "dm":
+				logTranscript(jsonMsg)
+				addDirectMessage(jsonMsg)
+			case "unread":
+				setUnread(jsonMsg)
+			case "purge":
+				logTranscript(jsonMsg)
+				applyPurge(jsonMsg)
+			case "roster":
+				setRoster(jsonMsg)
+				redrawMessages()
+			case "missedMention":
+				addMissedMention(jsonMsg)
+			case "historyMessage":
+				addHistoryMessage(jsonMsg)
+			case "historyEnd":
+				finishHistoryPage(jsonMsg)
+			case "searchResult":
+				addSearchResult(jsonMsg)
+			case "searchEnd":
+				showSearchResults(jsonMsg)
+			case "threadMessage":
+				// collect until threadEnd so the thread shows up in one piece
+				screenMutex.Lock()
+				pendingThread = append(pendingThread, entryFromMessage(jsonMsg))
+				screenMutex.Unlock()
+			case "threadEnd":
+				showThread(jsonMsg["id"])
 			default:
 				fmt.Println("Received unknown message type:", jsonMsg["type"])
 			}
@@ -676,151 +1150,74 @@ func main() {
 	}()
 
 	// screen init
-	clearScreen()
-	_, height := getTerminalSize()
-	themeColor := config["themeColor"].(string)
-	boldColor := themeColor
-	if !strings.HasPrefix(themeColor, "bold_") {
-		// handle magenta specially since there's no "bold_magenta", use "bold_purple"
-		if themeColor == "magenta" {
-			boldColor = "bold_purple"
-		} else {
-			boldColor = "bold_" + themeColor
-		}
-	}
-	colorCode, ok := ansiColors[boldColor]
-	if !ok {
-		colorCode = ansiColors["bold_blue"]
-	}
-	fmt.Printf("%s--- %s on %s:%d as %s ---%s\n",
-		colorCode,
+	banner := fmt.Sprintf("%s--- %s on %s:%d as %s ---%s",
+		themeColorCode(),
 		"tchat",
 		config["server"],
 		int(config["port"].(float64)),
 		config["username"],
 		ansiColors["reset"])
+	if plainMode {
+		printPlain(banner)
+	} else {
+		clearScreen()
+		fmt.Println(banner)
+	}
 	initChatArea()
 
-	// create a scanner that reads from stdin
-	scanner := bufio.NewScanner(os.Stdin)
-
-	for {
-		// clean the message line first and set cursor position
-		moveCursor(1, height-1)
-		clearLine()
+	// read keystrokes ourselves so incoming messages can redraw what's being typed,
+	// plain mode leaves that to the terminal
+	if !plainMode {
+		enableRawMode()
+	}
+	defer restoreTerminal()
+	go expireTypingUsers()
+	go watchResize()
+	go watchLatency(conn)
 
-		fmt.Print("Message: ")
+	stdinReader := bufio.NewReader(os.Stdin)
 
-		if scanner.Scan() {
-			message := scanner.Text()
+	for {
+		message, err := nextInputLine(stdinReader)
+		if err != nil {
+			exitClient(0, "Exiting chat...")
+		}
 
-			// check for empty message
-			if message == "" {
-				continue
-			}
+		// check for empty message
+		if message == "" {
+			continue
+		}
 
-			// char limit check
-			if len(message) > messageCharLimit {
-				message = message[:messageCharLimit] // truncate message if too long
-				addServerMessage(fmt.Sprintf("Message too long, truncated to %d characters.", messageCharLimit), "bold_red")
-				redrawMessages()
-			}
+		// char limit check
+		if utf8.RuneCountInString(message) > messageCharLimit {
+			message = string([]rune(message)[:messageCharLimit]) // truncate message if too long, by character so none get cut in half
+			addServerMessage(fmt.Sprintf("Message too long, truncated to %d characters.", messageCharLimit), "bold_red")
+			redrawMessages()
+		}
 
-			// ratelimit check
-			if !canSendMessage() {
-				addServerMessage("You are sending messages too fast, please wait a bit.", "bold_red")
-				redrawMessages()
-				continue
-			}
+		// ratelimit check
+		if !canSendMessage() {
+			addServerMessage("You are sending messages too fast, please wait a bit.", "bold_red")
+			redrawMessages()
+			continue
+		}
 
-			// command handling
-			if strings.HasPrefix(message, "//") {
-				// split command and arguments
-				cmdLine := strings.TrimSpace(message[2:])
-				parts := strings.Fields(cmdLine)
-				cmd := parts[0]
-				args := parts[1:]
-
-				switch cmd {
-				case "clear":
-					clearMessages()
-					addServerMessage("Chat cleared.")
-					redrawMessages()
-				case "color":
-					if len(args) < 1 {
-						addServerMessage("Usage: //color <color>", "bold_red")
-						redrawMessages()
-						continue
-					}
-					newColor := validateColorName(args[0])
-					if newColor != config["color"] {
-						config["color"] = newColor
-						addServerMessage(fmt.Sprintf("Color changed to %s.", newColor), "bold_green")
-					} else {
-						addServerMessage(fmt.Sprintf("Color is already set to %s.", newColor), "bold_yellow")
-					}
-					redrawMessages()
-				case "ping":
-					sendPing(conn)
-				case "mute":
-					if len(args) < 1 {
-						addServerMessage("Usage: //mute <username>", "bold_red")
-						redrawMessages()
-						continue
-					}
-					userToMute := args[0]
-					if userToMute == config["username"].(string) {
-						addServerMessage("You cannot mute yourself.", "bold_red")
-						redrawMessages()
-						continue
-					}
-					if muteList[userToMute] {
-						addServerMessage(fmt.Sprintf("User %s is already muted.", userToMute), "bold_yellow")
-					} else {
-						addMute(userToMute)
-					}
-				case "unmute":
-					if len(args) < 1 {
-						addServerMessage("Usage: //unmute <username>", "bold_red")
-						redrawMessages()
-						continue
-					}
-					userToUnmute := args[0]
-					if userToUnmute == config["username"].(string) {
-						addServerMessage("You cannot unmute yourself.", "bold_red")
-						redrawMessages()
-						continue
-					}
-					if _, exists := muteList[userToUnmute]; !exists {
-						addServerMessage(fmt.Sprintf("User %s is not muted.", userToUnmute), "bold_yellow")
-					} else {
-						removeMute(userToUnmute)
-					}
-				case "mutelist":
-					if len(muteList) == 0 {
-						addServerMessage("You have no muted users.", "bold_yellow")
-					} else {
-						muteListMsg := "Muted users: "
-						for user := range muteList {
-							muteListMsg += user + ", "
-						}
-						muteListMsg = strings.TrimSuffix(muteListMsg, ", ")
-						addServerMessage(muteListMsg, "bold_yellow")
-						redrawMessages()
-					}
-				case "exit", "quit", "bye":
-					fmt.Println("Exiting chat...")
-					os.Exit(0)
-				default:
-					addServerMessage(fmt.Sprintf("Unknown command: %s", message[2:]), "bold_red")
-					redrawMessages()
-					continue
-				}
+		// command handling
+		if strings.HasPrefix(message, "//") {
+			// split command and arguments
+			cmdLine := strings.TrimSpace(message[2:])
+			parts := strings.Fields(cmdLine)
+			if command, ok := commands[parts[0]]; ok {
+				command.run(conn, cmdLine, parts[1:])
 			} else {
-				sendMessage(conn, config["username"].(string), message, validateColorName(config["color"].(string)))
+				addServerMessage(fmt.Sprintf("Unknown command: %s", message[2:]), "bold_red")
 				redrawMessages()
 			}
-
+		} else {
+			sendMessage(conn, config["username"].(string), message, validateColorName(config["color"].(string)))
+			clearUnread()
+			scrollToBottom() // we'll want to see our own message
+			redrawMessages()
 		}
 	}
 }
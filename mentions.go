@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	maxMentions         = 100             // mentions kept for the //mentions view
+	mentionNotifyMaxAge = 1 * time.Minute // don't ping for old messages replayed from history
+)
+
+// messages that mentioned us, oldest first
+var mentions []*chatEntry
+
+// mentions that arrived since the //mentions view was last opened
+var unseenMentions int
+
+var mentionRegex *regexp.Regexp
+var mentionRegexUser string
+
+// matches @username for our own username, rebuilt if the username changes
+func mentionPattern() *regexp.Regexp {
+	username, _ := config["username"].(string)
+	if mentionRegex == nil || mentionRegexUser != username {
+		mentionRegex = regexp.MustCompile(`(?i)(^|[^\w@])(@` + regexp.QuoteMeta(username) + `)([^\w]|$)`)
+		mentionRegexUser = username
+	}
+	return mentionRegex
+}
+
+// checks whether a message mentions us
+func mentionsMe(text string) bool {
+	return mentionPattern().MatchString(text)
+}
+
+// colors our @username in a rendered line with the theme color
+func highlightMentions(line string) string {
+	return mentionPattern().ReplaceAllString(line, "${1}"+mentionColorCode()+"${2}"+ansiColors["reset"]+"${3}")
+}
+
+// the banner color of the theme, or the bold version of the configured theme color
+func themeColorCode() string {
+	if activeTheme.Banner != "" {
+		return colorCode(activeTheme.Banner)
+	}
+	themeColor, _ := config["themeColor"].(string)
+	if _, named := ansiColors[themeColor]; !named && isValidColor(themeColor) {
+		return "\033[1m" + colorCode(themeColor) // 256 color or #rrggbb, bold doesn't need a name of its own
+	}
+	boldColor := themeColor
+	if !strings.HasPrefix(themeColor, "bold_") {
+		// handle magenta specially since there's no "bold_magenta", use "bold_purple"
+		if themeColor == "magenta" {
+			boldColor = "bold_purple"
+		} else {
+			boldColor = "bold_" + themeColor
+		}
+	}
+	if colorCode, ok := ansiColors[boldColor]; ok {
+		return colorCode
+	}
+	return ansiColors["bold_blue"]
+}
+
+// remembers a message that mentioned us and lets the user know about it
+func recordMention(entry *chatEntry) {
+	screenMutex.Lock()
+	mentions = append(mentions, entry)
+	if len(mentions) > maxMentions {
+		mentions = mentions[len(mentions)-maxMentions:]
+	}
+	if activeView == nil || activeView.name != "mentions" {
+		unseenMentions++
+	}
+	screenMutex.Unlock()
+
+	// replayed history shouldn't ring the bell
+	if !entry.timestamp.IsZero() && time.Since(entry.timestamp) > mentionNotifyMaxAge {
+		return
+	}
+	notifyMention(entry)
+}
+
+// rings the terminal bell or sends a desktop notification for a mention or DM, depending on mentionNotification
+func notifyMention(entry *chatEntry) {
+	mode, _ := config["mentionNotification"].(string)
+	switch mode {
+	case "none":
+		return
+	case "osc9":
+		// OSC 9 is picked up as a desktop notification by terminals like iTerm2, kitty and Windows Terminal
+		what := "mentioned you"
+		if entry.dm {
+			what = "sent you a DM"
+		}
+		text := strings.NewReplacer("\a", "", "\033", "").Replace(fmt.Sprintf("%s %s: %s", entry.user, what, entry.text))
+		fmt.Fprintf(os.Stdout, "\033]9;%s\a", truncateText(text, 200))
+	default:
+		fmt.Fprint(os.Stdout, "\a")
+	}
+}
+
+// opens the //mentions view
+func showMentions() {
+	screenMutex.Lock()
+	view := &paneView{
+		name:    "mentions",
+		title:   fmt.Sprintf("--- mentions (%d) ---", len(mentions)),
+		entries: append([]*chatEntry(nil), mentions...),
+		empty:   "Nobody has mentioned you yet.",
+	}
+	activeView = view
+	unseenMentions = 0
+	screenMutex.Unlock()
+	redrawMessages()
+}
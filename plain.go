@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/BananaJeans/tchat/internal/sanitize"
+)
+
+// plain mode prints messages as lines added to the end of the output and never moves the cursor,
+// for screen readers, `script` recordings and dumb terminals. typing goes through the terminal's
+// own line editing
+var plainMode bool
+
+// NO_COLOR (https://no-color.org) turns colors off, bold and the like are kept
+var noColor = os.Getenv("NO_COLOR") != ""
+
+// what plain mode has printed so far, guarded by screenMutex
+var (
+	plainLastDay      string // day of the last message printed, for the day separators
+	plainDividerShown bool   // the "new messages" divider has been printed
+)
+
+const plainWidth = 1 << 16 // long lines are left for the terminal or screen reader to wrap
+
+// whether to run in plain mode: asked for with --plain, or a terminal that can't do anything else
+func wantPlainMode(args []string) bool {
+	for _, arg := range args {
+		if arg == "--plain" {
+			return true
+		}
+	}
+	return os.Getenv("TERM") == "dumb"
+}
+
+// turns every color into the terminal's default one, keeping bold, for NO_COLOR
+func applyNoColor() {
+	if !noColor {
+		return
+	}
+	for name, code := range ansiColors {
+		switch {
+		case name == "reset":
+		case code[2] == '1': // "\033[1;3xm"
+			ansiColors[name] = "\033[1;39m"
+		default:
+			ansiColors[name] = "\033[39m"
+		}
+	}
+	markupStyles['`'] = "" // code spans are left as typed, backticks and all
+}
+
+// prints one line of plain output, without any escape codes if colors are off or the terminal can't do them
+func printPlain(line string) {
+	if noColor || os.Getenv("TERM") == "dumb" {
+		line = sanitize.Line(line) // takes the escape codes out along with everything else
+	}
+	fmt.Println(line)
+}
+
+// prints entries that haven't been printed yet and any view that was opened, caller must hold screenMutex
+func printNewEntries() {
+	today := time.Now().Format("2006-01-02")
+	for _, entry := range entries {
+		if entry.printed {
+			continue
+		}
+		entry.printed = true
+		if !entry.timestamp.IsZero() {
+			local := entry.timestamp.Local()
+			day := local.Format("2006-01-02")
+			if day != plainLastDay && (plainLastDay != "" || day != today) {
+				printPlain(daySeparator(local))
+			}
+			plainLastDay = day
+		}
+		if !plainDividerShown && isFirstUnread(entry, unreadAfter) {
+			printPlain(unreadDivider())
+			plainDividerShown = true
+		}
+		for _, line := range renderTimedEntry(entry, plainWidth, timestampFormat()) {
+			printPlain(line)
+		}
+	}
+
+	// views are printed once, and then we're back in the chat since there's nothing to cover
+	if activeView != nil {
+		printPlain(activeView.title)
+		if len(activeView.entries) == 0 {
+			printPlain(activeView.empty)
+		}
+		layout := timestampFormat()
+		if layout == "" && activeView.showTimes {
+			layout = fallbackTimestampFormat
+		}
+		for _, line := range renderTimedEntries(activeView.entries, plainWidth, layout, 0) {
+			printPlain(line)
+		}
+		printPlain("--- end of " + activeView.name + " ---")
+		activeView = nil
+	}
+}
+
+// says in words what the full screen would have shown by changing a message in place
+func announce(format string, args ...interface{}) {
+	if plainMode {
+		addServerMessage(fmt.Sprintf(format, args...))
+	}
+}
+
+// names a message for an announcement, with who wrote it if it's still in the chat
+func describeMessage(id int64) string {
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	for _, entry := range entries {
+		if entry.id == id && !entry.notice {
+			return fmt.Sprintf("@%s's message #%d", entry.user, id)
+		}
+	}
+	return fmt.Sprintf("Message #%d", id)
+}
@@ -0,0 +1,61 @@
+package main
+
+import "time"
+
+// shown instead of the quote of a purged message, same as the server uses
+const purgedSnippet = "(removed)"
+
+// which entries a purge from the server removes, nil if it doesn't say
+func purgeMatcher(jsonMsg map[string]string) func(entry *chatEntry) bool {
+	if user := jsonMsg["purgeUser"]; user != "" {
+		return func(entry *chatEntry) bool {
+			return !entry.notice && entry.user == user
+		}
+	}
+	since, err := time.Parse(time.RFC3339, jsonMsg["since"])
+	if err != nil {
+		return nil
+	}
+	return func(entry *chatEntry) bool {
+		return !entry.notice && !entry.timestamp.IsZero() && !entry.timestamp.Before(since)
+	}
+}
+
+// drops the entries purge matches from a list, blanking quotes of them in replies that stay
+func purgeEntries(list []*chatEntry, purge func(entry *chatEntry) bool) []*chatEntry {
+	removed := make(map[int64]bool)
+	kept := list[:0:0]
+	for _, entry := range list {
+		if purge(entry) {
+			if entry.id != 0 {
+				removed[entry.id] = true
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	for _, entry := range kept {
+		if removed[entry.replyTo] {
+			entry.replySnippet = purgedSnippet
+		}
+	}
+	return kept
+}
+
+// the server removed messages from a user or since some time, take them off the screen too
+func applyPurge(jsonMsg map[string]string) {
+	purge := purgeMatcher(jsonMsg)
+	if purge == nil {
+		return
+	}
+	screenMutex.Lock()
+	entries = purgeEntries(entries, purge)
+	sessionMessages = purgeEntries(sessionMessages, purge)
+	mentions = purgeEntries(mentions, purge)
+	if activeView != nil {
+		activeView.entries = purgeEntries(activeView.entries, purge)
+	}
+	screenMutex.Unlock()
+	addServerMessage(jsonMsg["message"], "bold_yellow")
+	redrawMessages()
+}
@@ -0,0 +1,13 @@
+package main
+
+// lays the screen out again for the new terminal size, long lines are re-wrapped as they're redrawn
+func handleResize() {
+	if plainMode {
+		return // lines are left for the terminal to wrap
+	}
+	screenMutex.Lock()
+	initChatArea()
+	clearScreen() // anything drawn past the new edges would stay otherwise
+	screenMutex.Unlock()
+	redrawMessages()
+}
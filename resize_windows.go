@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import "time"
+
+// windows has no SIGWINCH, so check the size every so often instead
+func watchResize() {
+	width, height := getTerminalSize()
+	for range time.Tick(250 * time.Millisecond) {
+		w, h := getTerminalSize()
+		if w != width || h != height {
+			width, height = w, h
+			handleResize()
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "github.com/BananaJeans/tchat/internal/sanitize"
+
+// cleans every field of a message from the server, only the message text can have newlines
+func sanitizeMessage(jsonMsg map[string]string) {
+	for key, value := range jsonMsg {
+		if key == "message" {
+			jsonMsg[key] = sanitize.Text(value)
+		} else {
+			jsonMsg[key] = sanitize.Line(value)
+		}
+	}
+}
+
+// cleans a list of strings sent as json inside a field, like the roster
+func sanitizeList(list []string) []string {
+	for i, item := range list {
+		list[i] = sanitize.Line(item)
+	}
+	return list
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestSanitizeMessage(t *testing.T) {
+	msg := map[string]string{
+		"type":    "message",
+		"user":    "\u001b[2Jmallory\n",
+		"message": "hi\u001b]0;pwned\u0007\nthere",
+		"color":   "red\r",
+	}
+	sanitizeMessage(msg)
+	want := map[string]string{"type": "message", "user": "mallory ", "message": "hi\nthere", "color": "red"}
+	for key, value := range want {
+		if msg[key] != value {
+			t.Errorf("%s = %q, want %q", key, msg[key], value)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+const maxScrollback = 5000 // entries kept in the chat, the oldest go first
+
+// scroll state of the message pane, guarded by screenMutex
+var (
+	scrollOffset   int       // lines hidden below the bottom of the pane, 0 when following the chat
+	scrollNewBelow int       // messages that arrived while scrolled up
+	scrolledView   *paneView // view the offset belongs to, switching views starts at the bottom again
+	serverConn     net.Conn  // for fetching older messages when scrolling past the top
+)
+
+// keeps the view still when an entry is added below it, caller must hold screenMutex
+func keepScrollPosition(entry *chatEntry) {
+	if scrollOffset == 0 || activeView != nil {
+		return
+	}
+	scrollOffset += len(renderTimedEntry(entry, messageWidth(), timestampFormat()))
+	if !entry.notice {
+		scrollNewBelow++
+	}
+}
+
+// the lines that fit in the message pane at the current scroll position, caller must hold screenMutex
+func visibleLines(lines []string) []string {
+	if activeView != scrolledView {
+		scrolledView = activeView
+		scrollOffset = 0
+		scrollNewBelow = 0
+	}
+	maxOffset := len(lines) - maxMessages
+	if maxOffset < 0 {
+		maxOffset = 0
+	}
+	if scrollOffset > maxOffset {
+		scrollOffset = maxOffset
+	}
+	if scrollOffset == 0 {
+		scrollNewBelow = 0
+	}
+	end := len(lines) - scrollOffset
+	start := end - maxMessages
+	if start < 0 {
+		start = 0
+	}
+	return lines[start:end]
+}
+
+// moves the message pane by n lines, up if n is positive
+func scrollBy(n int) {
+	screenMutex.Lock()
+	total := len(renderEntries(messageWidth()))
+	maxOffset := total - maxMessages
+	if maxOffset < 0 {
+		maxOffset = 0
+	}
+	scrollOffset += n
+	if scrollOffset > maxOffset {
+		scrollOffset = maxOffset
+	}
+	if scrollOffset < 0 {
+		scrollOffset = 0
+	}
+	// ran into the top of the chat, see if the server has anything older
+	atTop := n > 0 && activeView == nil && scrollOffset == maxOffset
+	fetch := atTop && serverConn != nil && hasCapability(serverCapabilities, "history") && !historyExhausted && !historyRequestSent
+	if fetch {
+		historyToScrollback = true
+	}
+	screenMutex.Unlock()
+
+	if fetch {
+		requestOlderHistory(serverConn, defaultHistoryPageSize)
+	}
+	redrawMessages()
+}
+
+// lines PgUp and PgDn move, a screen less one line of overlap
+func scrollPage() int {
+	if maxMessages > 2 {
+		return maxMessages - 1
+	}
+	return 1
+}
+
+// goes back to following the chat
+func scrollToBottom() {
+	screenMutex.Lock()
+	changed := scrollOffset != 0
+	scrollOffset = 0
+	scrollNewBelow = 0
+	screenMutex.Unlock()
+	if changed {
+		redrawMessages()
+	}
+}
+
+// jumps to the oldest entry in the scrollback
+func scrollToTop() {
+	scrollBy(maxScrollback * 10) // clamped to the top when drawn
+}
+
+// shown in the status line while scrolled up, caller must hold screenMutex
+func scrollStatusText() string {
+	if scrollOffset == 0 {
+		return ""
+	}
+	if scrollNewBelow > 0 {
+		return fmt.Sprintf("↓ more below, %d new message(s), PgDn or //scroll bottom", scrollNewBelow)
+	}
+	return "↓ more below, PgDn or //scroll bottom"
+}
+
+// adds older messages fetched while scrolling to the top of the chat, caller must hold screenMutex
+func prependScrollback(page []*chatEntry) {
+	have := make(map[int64]bool, len(entries))
+	for _, entry := range entries {
+		if entry.id != 0 {
+			have[entry.id] = true
+		}
+	}
+	var older []*chatEntry
+	for _, entry := range page {
+		if !have[entry.id] {
+			older = append(older, entry)
+		}
+	}
+	entries = append(older, entries...)
+	if len(entries) > maxScrollback {
+		entries = entries[len(entries)-maxScrollback:] // full up, newer messages win
+	}
+}
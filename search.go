@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// results received so far for the search being run, guarded by screenMutex
+var pendingSearch []*chatEntry
+
+// asks the server to search its history
+func sendSearch(conn net.Conn, query string) {
+	screenMutex.Lock()
+	pendingSearch = nil
+	screenMutex.Unlock()
+	sendJSON(conn, map[string]string{
+		"type":  "searchRequest",
+		"user":  config["username"].(string),
+		"query": query,
+	})
+}
+
+// collects a search result until searchEnd arrives
+func addSearchResult(jsonMsg map[string]string) {
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	pendingSearch = append(pendingSearch, entryFromMessage(jsonMsg))
+}
+
+// shows the collected results in a view
+func showSearchResults(jsonMsg map[string]string) {
+	screenMutex.Lock()
+	results := pendingSearch
+	pendingSearch = nil
+	screenMutex.Unlock()
+
+	if jsonMsg["error"] != "" {
+		addServerMessage(jsonMsg["error"], "bold_red")
+		redrawMessages()
+		return
+	}
+
+	var shown []*chatEntry
+	for _, entry := range results {
+		if !muteList[entry.user] {
+			shown = append(shown, entry)
+		}
+	}
+	title := fmt.Sprintf("--- %d result(s) for %q ---", len(shown), jsonMsg["query"])
+	if jsonMsg["truncated"] == "true" {
+		title = fmt.Sprintf("--- newest %d results for %q, narrow it down to see older ones ---", len(shown), jsonMsg["query"])
+	}
+
+	screenMutex.Lock()
+	activeView = &paneView{
+		name:      "search",
+		title:     title,
+		entries:   shown,
+		empty:     "No messages found.",
+		showTimes: true,
+	}
+	screenMutex.Unlock()
+	redrawMessages()
+}
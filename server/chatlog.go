@@ -0,0 +1,269 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogFile          = "chat.jsonl"
+	defaultLogMaxSizeMB     = 10
+	defaultLogRetentionDays = 30
+	defaultRoom             = "main" // there's only one room for now, logged so old logs stay readable once there are more
+	segmentTimeLayout       = "20060102-150405"
+)
+
+// one line of the chat log
+type chatLogRecord struct {
+	Time    string `json:"ts"`
+	Event   string `json:"event"` // "message", "join", "leave", "kick", "ban", "clear" or "purge"
+	Room    string `json:"room"`
+	ID      string `json:"id,omitempty"`      // message ID, for "message"
+	User    string `json:"user,omitempty"`    // who sent the message, joined, left or got kicked/banned
+	IPHash  string `json:"ipHash,omitempty"`  // salted hash of the user's IP, so abuse can be traced without storing IPs
+	Message string `json:"message,omitempty"` // for "message", or what was purged for "purge"
+	Color   string `json:"color,omitempty"`   // the sender's color, for "message"
+	By      string `json:"by,omitempty"`      // who kicked, banned, cleared or purged
+}
+
+// long-lived JSON Lines logger, rotated by size and by day
+type chatLogger struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	size      int64
+	day       string // UTC day the open segment was started on
+	maxSize   int64
+	retention time.Duration // rotated segments older than this are deleted, 0 keeps them forever
+	salt      []byte
+
+	housekeeping sync.Mutex // only one compressAndPrune at a time, so two never gzip the same segment
+}
+
+// nil when logMessages is off
+var chatLog *chatLogger
+
+// opens (or creates) the chat log, rotating it right away if it's from a previous day
+func openChatLog(path string, maxSizeMB int, retentionDays int) (*chatLogger, error) {
+	l := &chatLogger{
+		path:      path,
+		maxSize:   int64(maxSizeMB) * 1024 * 1024,
+		retention: time.Duration(retentionDays) * 24 * time.Hour,
+	}
+
+	salt, err := loadLogSalt(path + ".salt")
+	if err != nil {
+		return nil, err
+	}
+	l.salt = salt
+
+	if err := l.openLocked(); err != nil {
+		return nil, err
+	}
+	info, err := l.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > 0 && info.ModTime().UTC().Format("2006-01-02") != l.day {
+		if err := l.rotateLocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	// finish off anything a previous run didn't get to compress or delete
+	go l.compressAndPrune()
+	return l, nil
+}
+
+// reads the salt used for IP hashes, making one if there isn't one yet
+func loadLogSalt(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && len(data) > 0 {
+		return data, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading log salt: %w", err)
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	salt = []byte(hex.EncodeToString(salt))
+	if err := os.WriteFile(path, salt, 0600); err != nil {
+		return nil, fmt.Errorf("error writing log salt: %w", err)
+	}
+	return salt, nil
+}
+
+func (l *chatLogger) openLocked() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening chat log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	l.day = time.Now().UTC().Format("2006-01-02")
+	return nil
+}
+
+// moves the current log aside and starts a new one, the old one is compressed in the background
+func (l *chatLogger) rotateLocked() error {
+	if err := l.file.Close(); err != nil {
+		log.Println("Error closing chat log:", err)
+	}
+	ext := filepath.Ext(l.path)
+	base := strings.TrimSuffix(l.path, ext) + "-" + time.Now().UTC().Format(segmentTimeLayout)
+	segment := base + ext
+	for n := 2; fileExists(segment) || fileExists(segment+".gz"); n++ {
+		segment = fmt.Sprintf("%s-%d%s", base, n, ext) // rotated twice in a second
+	}
+	if err := os.Rename(l.path, segment); err != nil {
+		log.Println("Error rotating chat log:", err)
+	} else {
+		go l.compressAndPrune()
+	}
+	return l.openLocked()
+}
+
+// rotated segments of this log, compressed or not
+func (l *chatLogger) segments(compressed bool) []string {
+	ext := filepath.Ext(l.path)
+	pattern := strings.TrimSuffix(l.path, ext) + "-*" + ext
+	if compressed {
+		pattern += ".gz"
+	}
+	matches, _ := filepath.Glob(pattern)
+	return matches
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// gzips a rotated segment and removes the original
+func compressSegment(path string) {
+	in, err := os.Open(path)
+	if err != nil {
+		log.Println("Error compressing chat log:", err)
+		return
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Println("Error compressing chat log:", err)
+		return
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Println("Error compressing chat log:", err)
+		os.Remove(path + ".gz") // keep the uncompressed one, we'll try again next start
+		return
+	}
+	in.Close()
+	os.Remove(path)
+}
+
+// compresses rotated segments, then deletes the ones past the retention period
+func (l *chatLogger) compressAndPrune() {
+	l.housekeeping.Lock()
+	defer l.housekeeping.Unlock()
+
+	for _, segment := range l.segments(false) {
+		compressSegment(segment)
+	}
+	if l.retention == 0 {
+		return
+	}
+	cutoff := time.Now().Add(-l.retention)
+	for _, segment := range l.segments(true) {
+		info, err := os.Stat(segment)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(segment); err != nil {
+			log.Println("Error removing old chat log:", err)
+		}
+	}
+}
+
+// writes one record, rotating first if the log is too big or from another day
+func (l *chatLogger) write(record chatLogRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record.Time = time.Now().UTC().Format(time.RFC3339)
+	if record.Room == "" {
+		record.Room = defaultRoom
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Println("Error marshaling chat log record:", err)
+		return
+	}
+	line = append(line, '\n')
+
+	if l.size > 0 && (l.size+int64(len(line)) > l.maxSize || time.Now().UTC().Format("2006-01-02") != l.day) {
+		if err := l.rotateLocked(); err != nil {
+			log.Println("Error reopening chat log:", err)
+			return
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Println("Error writing to chat log:", err)
+	}
+}
+
+// salted hash of an IP (the port is dropped), short enough to eyeball
+func (l *chatLogger) hashIP(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	sum := sha256.Sum256(append(append([]byte(nil), l.salt...), addr...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// logs an event if logging is on
+func logChatEvent(event string, client *ClientInfo, record chatLogRecord) {
+	if chatLog == nil {
+		return
+	}
+	record.Event = event
+	if client != nil {
+		if record.User == "" {
+			record.User = client.Username
+		}
+		record.IPHash = chatLog.hashIP(client.IP)
+	}
+	chatLog.write(record)
+}
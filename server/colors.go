@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// clients also pick colors as a 256 color palette number or #rrggbb, these turn them into
+// something the html export can use, the same way the client does for its terminal
+
+// the xterm defaults for the 16 ansi colors
+var basicPalette = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0}, {0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0}, {92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// levels of the 6x6x6 color cube in the 256 color palette
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// parses "#rrggbb"
+func parseHexColor(color string) ([3]int, bool) {
+	if len(color) != 7 || color[0] != '#' {
+		return [3]int{}, false
+	}
+	n, err := strconv.ParseUint(color[1:], 16, 32)
+	if err != nil {
+		return [3]int{}, false
+	}
+	return [3]int{int(n >> 16 & 0xff), int(n >> 8 & 0xff), int(n & 0xff)}, true
+}
+
+// parses a 256 color palette number, "0" to "255"
+func parsePaletteColor(color string) (int, bool) {
+	n, err := strconv.Atoi(color)
+	if err != nil || n < 0 || n > 255 || strconv.Itoa(n) != color {
+		return 0, false
+	}
+	return n, true
+}
+
+// what a 256 color palette entry looks like
+func paletteRGB(n int) [3]int {
+	switch {
+	case n < 16:
+		return basicPalette[n]
+	case n < 232:
+		n -= 16
+		return [3]int{cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]}
+	default:
+		gray := 8 + (n-232)*10
+		return [3]int{gray, gray, gray}
+	}
+}
+
+// a color as css #rrggbb, empty if it isn't a 256 color number or #rrggbb
+func cssColor(color string) string {
+	rgb, ok := parseHexColor(color)
+	if !ok {
+		n, isPalette := parsePaletteColor(color)
+		if !isPalette {
+			return ""
+		}
+		rgb = paletteRGB(n)
+	}
+	return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
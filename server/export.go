@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// a message as it ends up in an exported transcript
+type exportMessage struct {
+	ID      string    `json:"id,omitempty"`
+	Time    time.Time `json:"ts"`
+	Room    string    `json:"room"`
+	User    string    `json:"user"`
+	Color   string    `json:"color,omitempty"`
+	Message string    `json:"message"`
+	ReplyTo string    `json:"replyTo,omitempty"`
+	Edited  bool      `json:"edited,omitempty"`
+}
+
+// css colors for the color names clients pick, roughly what a terminal shows
+var exportColors = map[string]string{
+	"red":         "#cd3131",
+	"green":       "#0dbc79",
+	"yellow":      "#b5a000",
+	"blue":        "#2472c8",
+	"magenta":     "#bc3fbc",
+	"cyan":        "#11a8cd",
+	"white":       "#808080", // white on a white page isn't readable
+	"bold_black":  "#666666",
+	"bold_red":    "#f14c4c",
+	"bold_green":  "#23d18b",
+	"bold_yellow": "#c0a000",
+	"bold_blue":   "#3b8eea",
+	"bold_purple": "#d670d6",
+	"bold_cyan":   "#29b8db",
+	"bold_white":  "#999999",
+}
+
+// `tchat-server export`, writes a transcript of the history or chat logs, returns the exit code
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	source := flags.String("source", "history", "where to read messages from: history or logs")
+	input := flags.String("input", "", "history or chat log file to read (default: historyFile or logFile from tchatconfig.json)")
+	from := flags.String("from", "", "only messages sent on or after this (YYYY-MM-DD or RFC 3339, UTC)")
+	to := flags.String("to", "", "only messages sent up to this (a day includes the whole day)")
+	format := flags.String("format", "txt", "output format: txt, markdown, html or json")
+	room := flags.String("room", "", "only messages from this room")
+	users := flags.String("user", "", "only messages from these users, comma separated")
+	output := flags.String("o", "", "file to write to (default: stdout)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: tchat-server export [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	writeTranscript, ok := transcriptWriters[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, use txt, markdown, html or json\n", *format)
+		return 2
+	}
+
+	var fromTime, toTime time.Time
+	var err error
+	if *from != "" {
+		if fromTime, err = parseSearchDate(*from); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid --from:", err)
+			return 2
+		}
+	}
+	if *to != "" {
+		if toTime, err = parseSearchDate(*to); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid --to:", err)
+			return 2
+		}
+		if len(*to) == len(searchDateLayout) {
+			toTime = toTime.Add(24 * time.Hour) // the whole day
+		}
+	}
+
+	// use the paths from the config if there is one, without creating it like the server does
+	if _, err := os.Stat("./tchatconfig.json"); err == nil {
+		serverConfig = loadConfig()
+	}
+
+	var messages []exportMessage
+	switch *source {
+	case "history":
+		path := *input
+		if path == "" {
+			path = defaultHistoryFile
+			if configured, ok := serverConfig["historyFile"].(string); ok && configured != "" {
+				path = configured
+			}
+		}
+		messages, err = readHistoryForExport(path)
+	case "logs":
+		path := *input
+		if path == "" {
+			path = defaultLogFile
+			if configured, ok := serverConfig["logFile"].(string); ok {
+				path = configured
+			}
+		}
+		messages, err = readChatLogsForExport(path)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown source %q, use history or logs\n", *source)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading messages:", err)
+		return 1
+	}
+
+	// filter
+	wantUsers := make(map[string]bool)
+	for _, user := range strings.Split(*users, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			wantUsers[strings.ToLower(user)] = true
+		}
+	}
+	filtered := messages[:0]
+	for _, msg := range messages {
+		if !fromTime.IsZero() && msg.Time.Before(fromTime) {
+			continue
+		}
+		if !toTime.IsZero() && !msg.Time.Before(toTime) {
+			continue
+		}
+		if *room != "" && msg.Room != *room {
+			continue
+		}
+		if len(wantUsers) > 0 && !wantUsers[strings.ToLower(msg.User)] {
+			continue
+		}
+		filtered = append(filtered, msg)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating output file:", err)
+			return 1
+		}
+		defer out.Close()
+	}
+	writer := bufio.NewWriter(out)
+	if err := writeTranscript(writer, filtered); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing transcript:", err)
+		return 1
+	}
+	if err := writer.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing transcript:", err)
+		return 1
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d messages to %s\n", len(filtered), *output)
+	}
+	return 0
+}
+
+// the messages in the history store, with edits and deletes already applied
+func readHistoryForExport(path string) ([]exportMessage, error) {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+	if err := readHistoryLogLocked(path); err != nil {
+		return nil, err
+	}
+
+	var messages []exportMessage
+	for _, msg := range messageHistory {
+		ts, _ := time.Parse(time.RFC3339, msg["timestamp"])
+		messages = append(messages, exportMessage{
+			ID:      msg["id"],
+			Time:    ts,
+			Room:    defaultRoom, // history only has the one room
+			User:    msg["user"],
+			Color:   msg["color"],
+			Message: msg["message"],
+			ReplyTo: msg["replyTo"],
+			Edited:  msg["edited"] == "true",
+		})
+	}
+	return messages, nil
+}
+
+// the messages in the chat log and its rotated segments, oldest first
+func readChatLogsForExport(path string) ([]exportMessage, error) {
+	l := &chatLogger{path: path}
+	files := append(l.segments(true), l.segments(false)...)
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no chat logs found at %s", path)
+	}
+
+	var messages []exportMessage
+	for _, file := range files {
+		if err := readChatLogFile(file, &messages); err != nil {
+			return nil, err
+		}
+	}
+	// segments can be named out of order if they were rotated within a second
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Time.Before(messages[j].Time) })
+	return messages, nil
+}
+
+func readChatLogFile(path string, messages *[]exportMessage) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	// not a Scanner, one huge garbage line shouldn't stop the export
+	lines := bufio.NewReader(reader)
+	for {
+		line, err := lines.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) == 0 {
+				return nil
+			}
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		var record chatLogRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.Event != "message" {
+			continue // other events, or lines from the old text format
+		}
+		ts, _ := time.Parse(time.RFC3339, record.Time)
+		*messages = append(*messages, exportMessage{
+			ID:      record.ID,
+			Time:    ts,
+			Room:    record.Room,
+			User:    record.User,
+			Color:   record.Color,
+			Message: record.Message,
+		})
+	}
+}
+
+var transcriptWriters = map[string]func(io.Writer, []exportMessage) error{
+	"txt":      writeTextTranscript,
+	"markdown": writeMarkdownTranscript,
+	"md":       writeMarkdownTranscript,
+	"html":     writeHTMLTranscript,
+	"json":     writeJSONTranscript,
+}
+
+const exportTimeLayout = "2006-01-02 15:04"
+
+func writeTextTranscript(w io.Writer, messages []exportMessage) error {
+	for _, msg := range messages {
+		reply := ""
+		if msg.ReplyTo != "" {
+			reply = fmt.Sprintf("(reply to #%s) ", msg.ReplyTo)
+		}
+		id := ""
+		if msg.ID != "" { // chat log records from before IDs don't have one
+			id = "#" + msg.ID + " "
+		}
+		if _, err := fmt.Fprintf(w, "%s %s[%s] %s%s\n", msg.Time.UTC().Format(exportTimeLayout), id, msg.User, reply, msg.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapes the characters markdown would otherwise format
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+func writeMarkdownTranscript(w io.Writer, messages []exportMessage) error {
+	fmt.Fprintf(w, "# tchat transcript\n\n%d messages, times in UTC.\n", len(messages))
+	day := ""
+	for _, msg := range messages {
+		if d := msg.Time.UTC().Format("2006-01-02"); d != day {
+			day = d
+			fmt.Fprintf(w, "\n## %s\n\n", day)
+		}
+		reply := ""
+		if msg.ReplyTo != "" {
+			reply = fmt.Sprintf("↳ #%s ", msg.ReplyTo)
+		}
+		if _, err := fmt.Fprintf(w, "- `%s` **%s**: %s%s\n", msg.Time.UTC().Format("15:04"), markdownEscaper.Replace(msg.User), reply, markdownEscaper.Replace(msg.Message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHTMLTranscript(w io.Writer, messages []exportMessage) error {
+	fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tchat transcript</title>
+<style>
+body { font-family: monospace; max-width: 60em; margin: 2em auto; }
+.day { margin-top: 1.5em; color: #666; border-bottom: 1px solid #ddd; }
+.time, .id { color: #999; }
+.user { font-weight: bold; }
+.reply { color: #999; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>tchat transcript</h1>
+`)
+	fmt.Fprintf(w, "<p>%d messages, times in UTC.</p>\n", len(messages))
+	day := ""
+	for _, msg := range messages {
+		if d := msg.Time.UTC().Format("2006-01-02"); d != day {
+			day = d
+			fmt.Fprintf(w, "<h2 class=\"day\">%s</h2>\n", day)
+		}
+		color, ok := exportColors[msg.Color]
+		if !ok {
+			color = cssColor(msg.Color)
+		}
+		if color == "" {
+			color = exportColors["blue"] // same default as the client
+		}
+		reply := ""
+		if msg.ReplyTo != "" {
+			reply = fmt.Sprintf(`<span class="reply">↳ #%s</span> `, html.EscapeString(msg.ReplyTo))
+		}
+		anchor, id := "", ""
+		if msg.ID != "" {
+			anchor = fmt.Sprintf(` id="m%s"`, html.EscapeString(msg.ID))
+			id = fmt.Sprintf(`<span class="id">#%s</span> `, html.EscapeString(msg.ID))
+		}
+		if _, err := fmt.Fprintf(w, "<div%s><span class=\"time\">%s</span> %s<span class=\"user\" style=\"color: %s\">%s</span>: %s%s</div>\n",
+			anchor, msg.Time.UTC().Format("15:04"), id, color,
+			html.EscapeString(msg.User), reply, html.EscapeString(msg.Message)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(w, "</body>\n</html>\n")
+	return err
+}
+
+func writeJSONTranscript(w io.Writer, messages []exportMessage) error {
+	if messages == nil {
+		messages = []exportMessage{} // [] rather than null
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(messages)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var exportTestMessages = []exportMessage{
+	{ID: "7", Time: time.Date(2024, 6, 1, 9, 5, 0, 0, time.UTC), Room: "main", User: "alice", Color: "red", Message: "hi *all*, see <b>this</b> & [that](x)"},
+	{ID: "8", Time: time.Date(2024, 6, 1, 9, 6, 0, 0, time.UTC), Room: "main", User: "b_o_b", Color: "202", Message: "`code` #1 | ~x~ \\o/", ReplyTo: "7"},
+	{Time: time.Date(2024, 6, 2, 10, 0, 0, 0, time.UTC), Room: "main", User: `"quoted"`, Color: "nonsense", Message: "from an old log"},
+}
+
+func writeTestTranscript(t *testing.T, format string, messages []exportMessage) string {
+	t.Helper()
+	var b strings.Builder
+	if err := transcriptWriters[format](&b, messages); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestWriteTextTranscript(t *testing.T) {
+	got := writeTestTranscript(t, "txt", exportTestMessages)
+	want := "2024-06-01 09:05 #7 [alice] hi *all*, see <b>this</b> & [that](x)\n" +
+		"2024-06-01 09:06 #8 [b_o_b] (reply to #7) `code` #1 | ~x~ \\o/\n" +
+		"2024-06-02 10:00 [\"quoted\"] from an old log\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteMarkdownTranscript(t *testing.T) {
+	got := writeTestTranscript(t, "markdown", exportTestMessages)
+	want := "# tchat transcript\n\n3 messages, times in UTC.\n" +
+		"\n## 2024-06-01\n\n" +
+		"- `09:05` **alice**: hi \\*all\\*, see \\<b\\>this\\</b\\> & \\[that\\](x)\n" +
+		"- `09:06` **b\\_o\\_b**: ↳ #7 \\`code\\` \\#1 \\| \\~x\\~ \\\\o/\n" +
+		"\n## 2024-06-02\n\n" +
+		"- `10:00` **\"quoted\"**: from an old log\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteHTMLTranscript(t *testing.T) {
+	got := writeTestTranscript(t, "html", exportTestMessages)
+	for _, want := range []string{
+		"<p>3 messages, times in UTC.</p>\n",
+		`<h2 class="day">2024-06-01</h2>`,
+		`<div id="m7"><span class="time">09:05</span> <span class="id">#7</span> <span class="user" style="color: #cd3131">alice</span>: hi *all*, see &lt;b&gt;this&lt;/b&gt; &amp; [that](x)</div>`,
+		`<div id="m8"><span class="time">09:06</span> <span class="id">#8</span> <span class="user" style="color: #ff5f00">b_o_b</span>: <span class="reply">↳ #7</span> ` + "`code` #1 | ~x~ \\o/</div>",
+		`<h2 class="day">2024-06-02</h2>`,
+		`<div><span class="time">10:00</span> <span class="user" style="color: #2472c8">&#34;quoted&#34;</span>: from an old log</div>`,
+		"</body>\n</html>\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	for _, bad := range []string{"<b>this", `id="m"`, "#</span>"} {
+		if strings.Contains(got, bad) {
+			t.Errorf("found %q in:\n%s", bad, got)
+		}
+	}
+}
+
+func TestWriteJSONTranscript(t *testing.T) {
+	for _, messages := range [][]exportMessage{nil, {}} {
+		if got := writeTestTranscript(t, "json", messages); got != "[]\n" {
+			t.Errorf("no messages: got %q, want an empty array", got)
+		}
+	}
+
+	got := writeTestTranscript(t, "json", exportTestMessages)
+	if !strings.Contains(got, `"message": "hi *all*, see <b>this</b> & [that](x)"`) {
+		t.Errorf("html was escaped:\n%s", got)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal([]byte(got), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 3 {
+		t.Fatalf("got %d messages, want 3", len(decoded))
+	}
+	if decoded[0]["id"] != "7" || decoded[0]["ts"] != "2024-06-01T09:05:00Z" || decoded[1]["replyTo"] != "7" {
+		t.Errorf("got %v", decoded[:2])
+	}
+	if _, ok := decoded[2]["id"]; ok {
+		t.Errorf("message without an ID has one: %v", decoded[2])
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryFile        = "history.jsonl"
+	defaultHistoryMaxMessages = 5000 // messages kept around for replies, threads and replay
+	defaultHistoryMaxAgeDays  = 30
+	defaultHistorySendCount   = 10 // messages sent to new clients on join
+	replySnippetLength        = 40 // runes of the parent message quoted in a reply
+	maxHistoryPageSize        = 100
+)
+
+// one line of the history log, the log is replayed in order on startup
+type historyRecord struct {
+	Op     string            `json:"op"`               // "meta", "add", "replace", "delete" or "clear"
+	ID     string            `json:"id,omitempty"`     // message ID, for "delete"
+	Msg    map[string]string `json:"msg,omitempty"`    // the whole message, for "add" and "replace"
+	LastID int64             `json:"lastId,omitempty"` // last ID handed out, for "meta"
+}
+
+// last message ID handed out, guarded by messageHistoryMutex
+var lastMessageID int64
+
+// position of each stored message in messageHistory, key: message ID, guarded by messageHistoryMutex
+var historyIndex = make(map[string]int)
+
+// append-only log backing messageHistory, nil if historyFile is empty (memory only)
+var historyLog *os.File
+var historyLogPath string
+
+// records in the log, once this grows well past the number of messages we compact it
+var historyLogRecords int
+
+// loads the history log from disk, applies retention and opens it for appending
+func openHistoryStore(path string) error {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	historyLogPath = path
+	if path == "" {
+		return nil // memory only
+	}
+
+	if err := readHistoryLogLocked(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error loading history: %w", err)
+	}
+
+	applyRetentionLocked()
+	// start every run from a compact log, which also drops anything retention removed
+	if err := compactHistoryLocked(); err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d messages from %s\n", len(messageHistory), path)
+	return nil
+}
+
+// replays the history log into memory, caller must hold messageHistoryMutex
+func readHistoryLogLocked(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var rec historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// probably a half-written line from a crash, skip it
+			log.Printf("Skipping bad history record on line %d: %v", line, err)
+			continue
+		}
+		applyHistoryRecordLocked(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading history file: %w", err)
+	}
+	return nil
+}
+
+// replays a single log record into memory, caller must hold messageHistoryMutex
+func applyHistoryRecordLocked(rec historyRecord) {
+	switch rec.Op {
+	case "meta":
+		if rec.LastID > lastMessageID {
+			lastMessageID = rec.LastID
+		}
+	case "add":
+		if rec.Msg == nil {
+			return
+		}
+		if id, err := strconv.ParseInt(rec.Msg["id"], 10, 64); err == nil && id > lastMessageID {
+			lastMessageID = id
+		}
+		historyIndex[rec.Msg["id"]] = len(messageHistory)
+		messageHistory = append(messageHistory, rec.Msg)
+	case "replace":
+		if i, ok := historyIndex[rec.Msg["id"]]; ok {
+			messageHistory[i] = rec.Msg
+		}
+	case "delete":
+		if i, ok := historyIndex[rec.ID]; ok {
+			messageHistory = append(messageHistory[:i:i], messageHistory[i+1:]...)
+			reindexHistoryLocked()
+		}
+	case "clear":
+		messageHistory = nil
+		reindexHistoryLocked()
+	}
+}
+
+// rebuilds historyIndex after messages were removed, caller must hold messageHistoryMutex
+func reindexHistoryLocked() {
+	historyIndex = make(map[string]int, len(messageHistory))
+	for i, msg := range messageHistory {
+		historyIndex[msg["id"]] = i
+	}
+}
+
+// writes a record to the end of the log, caller must hold messageHistoryMutex
+func appendHistoryRecordLocked(rec historyRecord) {
+	if historyLog == nil {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Println("Error marshaling history record:", err)
+		return
+	}
+	if _, err := historyLog.Write(append(data, '\n')); err != nil {
+		log.Println("Error writing history record:", err)
+		return
+	}
+	historyLogRecords++
+
+	// edits, reactions and dropped messages pile up in the log, rewrite it once it's mostly garbage
+	if historyLogRecords > 2*len(messageHistory)+100 {
+		if err := compactHistoryLocked(); err != nil {
+			log.Println("Error compacting history:", err)
+		}
+	}
+}
+
+// rewrites the log with just the messages we still have, caller must hold messageHistoryMutex
+func compactHistoryLocked() error {
+	if historyLogPath == "" {
+		return nil
+	}
+
+	tmpPath := historyLogPath + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("error creating history file: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	// keep the last ID so IDs of deleted messages are never handed out again
+	encoder.Encode(historyRecord{Op: "meta", LastID: lastMessageID})
+	for _, msg := range messageHistory {
+		encoder.Encode(historyRecord{Op: "add", Msg: msg})
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing history file: %w", err)
+	}
+	tmp.Close()
+
+	if historyLog != nil {
+		historyLog.Close()
+		historyLog = nil
+	}
+	if err := os.Rename(tmpPath, historyLogPath); err != nil {
+		return fmt.Errorf("error replacing history file: %w", err)
+	}
+	historyLog, err = os.OpenFile(historyLogPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening history file: %w", err)
+	}
+	historyLogRecords = len(messageHistory) + 1
+	return nil
+}
+
+// drops messages beyond historyMaxMessages or older than historyMaxAgeDays, caller must hold messageHistoryMutex
+func applyRetentionLocked() bool {
+	drop := 0
+
+	if maxMessages := configInt("historyMaxMessages", defaultHistoryMaxMessages); maxMessages > 0 && len(messageHistory) > maxMessages {
+		drop = len(messageHistory) - maxMessages
+	}
+
+	if maxAgeDays := configInt("historyMaxAgeDays", defaultHistoryMaxAgeDays); maxAgeDays > 0 {
+		cutoff := time.Now().Add(-time.Duration(maxAgeDays) * 24 * time.Hour)
+		// messages are in order, so stop at the first one that's new enough
+		for drop < len(messageHistory) {
+			ts, err := time.Parse(time.RFC3339, messageHistory[drop]["timestamp"])
+			if err != nil || ts.After(cutoff) {
+				break
+			}
+			drop++
+		}
+	}
+
+	if drop == 0 {
+		return false
+	}
+	messageHistory = append([]map[string]string(nil), messageHistory[drop:]...)
+	reindexHistoryLocked()
+	return true
+}
+
+// re-applies the history limits now rather than at the next message, returns whether anything was dropped
+func enforceHistoryRetention() bool {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	if !applyRetentionLocked() {
+		return false
+	}
+	if err := compactHistoryLocked(); err != nil {
+		log.Println("Error compacting history:", err)
+	}
+	return true
+}
+
+// gives a message its ID and timestamp and stores it in the history
+func recordMessage(message map[string]string) {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	lastMessageID++
+	message["id"] = strconv.FormatInt(lastMessageID, 10)
+	message["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+
+	historyIndex[message["id"]] = len(messageHistory)
+	messageHistory = append(messageHistory, message)
+	appendHistoryRecordLocked(historyRecord{Op: "add", Msg: message})
+
+	// dropped messages stay in the log until the next compaction, loading applies retention again anyway
+	applyRetentionLocked()
+}
+
+// the newest n stored messages, oldest first
+func recentMessages(n int) []map[string]string {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	start := len(messageHistory) - n
+	if start < 0 {
+		start = 0
+	}
+	return append([]map[string]string(nil), messageHistory[start:]...)
+}
+
+// forgets every stored message, used by //clearchat
+func clearHistory() {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	messageHistory = nil
+	reindexHistoryLocked()
+	// rewrite the store rather than appending a "clear", so the old messages are gone from disk too
+	if err := compactHistoryLocked(); err != nil {
+		log.Println("Error clearing history:", err)
+	}
+}
+
+// looks up a stored message by its ID, caller must hold messageHistoryMutex
+func findMessageLocked(id string) map[string]string {
+	if i, ok := historyIndex[id]; ok {
+		return messageHistory[i]
+	}
+	return nil
+}
+
+// swaps a stored message for an updated copy, caller must hold messageHistoryMutex
+func replaceMessageLocked(updated map[string]string) {
+	// replace rather than modify the stored map, it may still be getting marshaled elsewhere
+	if i, ok := historyIndex[updated["id"]]; ok {
+		messageHistory[i] = updated
+		appendHistoryRecordLocked(historyRecord{Op: "replace", Msg: updated})
+	}
+}
+
+// fills in the reply fields of message from its parent, returns false if the parent is unknown
+func attachReply(message map[string]string, parentID string) bool {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	parent := findMessageLocked(parentID)
+	if parent == nil {
+		return false
+	}
+
+	snippet := []rune(parent["message"])
+	if len(snippet) > replySnippetLength {
+		snippet = append(snippet[:replySnippetLength], '…')
+	}
+
+	message["replyTo"] = parent["id"]
+	message["replyUser"] = parent["user"]
+	message["replySnippet"] = string(snippet)
+	// replies to replies stay in the thread of the original message
+	if root := parent["threadRoot"]; root != "" {
+		message["threadRoot"] = root
+	} else {
+		message["threadRoot"] = parent["id"]
+	}
+	return true
+}
+
+// returns every stored message in the thread id belongs to, root first
+func threadMessages(id string) []map[string]string {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	msg := findMessageLocked(id)
+	if msg == nil {
+		return nil
+	}
+	root := msg["id"]
+	if msg["threadRoot"] != "" {
+		root = msg["threadRoot"]
+	}
+
+	var thread []map[string]string
+	for _, m := range messageHistory {
+		if m["id"] == root || m["threadRoot"] == root {
+			thread = append(thread, m)
+		}
+	}
+	return thread
+}
+
+// copies a stored message so it can be sent with a different type
+func copyMessage(message map[string]string) map[string]string {
+	c := make(map[string]string, len(message))
+	for k, v := range message {
+		c[k] = v
+	}
+	return c
+}
+
+// trims surrounding whitespace and checks that s looks like a message ID
+func parseMessageID(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 1 {
+		return "", false
+	}
+	return strconv.FormatInt(id, 10), true
+}
+
+// replaces the text of a stored message, only its author may do this
+func editMessage(id string, editor string, text string) (map[string]string, error) {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	msg := findMessageLocked(id)
+	if msg == nil {
+		return nil, fmt.Errorf("message #%s not found", id)
+	}
+	if msg["user"] != editor {
+		return nil, errors.New("you can only edit your own messages")
+	}
+
+	edited := copyMessage(msg)
+	edited["message"] = text
+	edited["edited"] = "true"
+	edited["editedAt"] = time.Now().UTC().Format(time.RFC3339)
+	replaceMessageLocked(edited)
+	return edited, nil
+}
+
+// removes a stored message, only its author or a moderator may do this
+func deleteMessage(id string, deleter string, isModerator bool) error {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	i, ok := historyIndex[id]
+	if !ok {
+		return fmt.Errorf("message #%s not found", id)
+	}
+	if messageHistory[i]["user"] != deleter && !isModerator {
+		return errors.New("you can only delete your own messages")
+	}
+	messageHistory = append(messageHistory[:i:i], messageHistory[i+1:]...)
+	reindexHistoryLocked()
+	appendHistoryRecordLocked(historyRecord{Op: "delete", ID: id})
+	return nil
+}
+
+// up to limit stored messages older than beforeID (or the newest ones if beforeID is empty),
+// oldest first, and whether there are even older ones left
+func messagesBefore(beforeID string, limit int) ([]map[string]string, bool) {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	end := len(messageHistory)
+	if before, err := strconv.ParseInt(beforeID, 10, 64); err == nil {
+		// IDs only go up, so find the first message that isn't older than beforeID
+		end = sort.Search(len(messageHistory), func(i int) bool {
+			id, _ := strconv.ParseInt(messageHistory[i]["id"], 10, 64)
+			return id >= before
+		})
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	return append([]map[string]string(nil), messageHistory[start:end]...), start > 0
+}
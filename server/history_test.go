@@ -0,0 +1,256 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// starts from an empty history with the given config, closing the log when the test is done
+func resetHistory(t *testing.T, config map[string]interface{}) {
+	t.Helper()
+	messageHistory = nil
+	historyIndex = make(map[string]int)
+	lastMessageID = 0
+	historyLog = nil
+	historyLogPath = ""
+	historyLogRecords = 0
+	serverConfig = config
+	t.Cleanup(func() {
+		if historyLog != nil {
+			historyLog.Close()
+			historyLog = nil
+		}
+	})
+}
+
+// writes a history log to a temp dir and returns its path
+func writeHistoryLog(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func historyIDs() []string {
+	ids := []string{}
+	for _, msg := range messageHistory {
+		ids = append(ids, msg["id"])
+	}
+	return ids
+}
+
+func TestReadHistoryLog(t *testing.T) {
+	cases := []struct {
+		name       string
+		lines      []string
+		wantIDs    []string
+		wantLastID int64
+		wantText   map[string]string // key: message ID, value: its text after replay
+	}{
+		{
+			name: "adds",
+			lines: []string{
+				`{"op":"add","msg":{"id":"1","user":"alice","message":"hi"}}`,
+				`{"op":"add","msg":{"id":"2","user":"bob","message":"hey"}}`,
+			},
+			wantIDs:    []string{"1", "2"},
+			wantLastID: 2,
+			wantText:   map[string]string{"1": "hi", "2": "hey"},
+		},
+		{
+			name: "meta keeps ids of deleted messages used",
+			lines: []string{
+				`{"op":"meta","lastId":9}`,
+				`{"op":"add","msg":{"id":"4","message":"old"}}`,
+			},
+			wantIDs:    []string{"4"},
+			wantLastID: 9,
+		},
+		{
+			name: "replace",
+			lines: []string{
+				`{"op":"add","msg":{"id":"1","message":"helo"}}`,
+				`{"op":"replace","msg":{"id":"1","message":"hello","edited":"true"}}`,
+				`{"op":"replace","msg":{"id":"7","message":"not here"}}`,
+			},
+			wantIDs:    []string{"1"},
+			wantLastID: 1,
+			wantText:   map[string]string{"1": "hello"},
+		},
+		{
+			name: "delete",
+			lines: []string{
+				`{"op":"add","msg":{"id":"1","message":"a"}}`,
+				`{"op":"add","msg":{"id":"2","message":"b"}}`,
+				`{"op":"add","msg":{"id":"3","message":"c"}}`,
+				`{"op":"delete","id":"2"}`,
+				`{"op":"replace","msg":{"id":"3","message":"c2"}}`,
+			},
+			wantIDs:    []string{"1", "3"},
+			wantLastID: 3,
+			wantText:   map[string]string{"3": "c2"},
+		},
+		{
+			name: "clear",
+			lines: []string{
+				`{"op":"add","msg":{"id":"1","message":"a"}}`,
+				`{"op":"clear"}`,
+				`{"op":"add","msg":{"id":"2","message":"b"}}`,
+			},
+			wantIDs:    []string{"2"},
+			wantLastID: 2,
+		},
+		{
+			name: "truncated last line",
+			lines: []string{
+				`{"op":"add","msg":{"id":"1","message":"a"}}`,
+				`{"op":"add","msg":{"id":"2","mess`,
+			},
+			wantIDs:    []string{"1"},
+			wantLastID: 1,
+		},
+		{
+			name: "bad line in the middle",
+			lines: []string{
+				`{"op":"add","msg":{"id":"1","message":"a"}}`,
+				`not json`,
+				`{"op":"add","msg":{"id":"2","message":"b"}}`,
+			},
+			wantIDs:    []string{"1", "2"},
+			wantLastID: 2,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resetHistory(t, map[string]interface{}{})
+			if err := readHistoryLogLocked(writeHistoryLog(t, c.lines...)); err != nil {
+				t.Fatal(err)
+			}
+			if got := historyIDs(); !reflect.DeepEqual(got, c.wantIDs) {
+				t.Errorf("ids = %v, want %v", got, c.wantIDs)
+			}
+			if lastMessageID != c.wantLastID {
+				t.Errorf("lastMessageID = %d, want %d", lastMessageID, c.wantLastID)
+			}
+			for id, text := range c.wantText {
+				if msg := findMessageLocked(id); msg == nil || msg["message"] != text {
+					t.Errorf("message #%s = %v, want text %q", id, msg, text)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenHistoryStoreCompacts(t *testing.T) {
+	resetHistory(t, map[string]interface{}{})
+	path := writeHistoryLog(t,
+		`{"op":"add","msg":{"id":"1","message":"a"}}`,
+		`{"op":"add","msg":{"id":"2","message":"b"}}`,
+		`{"op":"replace","msg":{"id":"1","message":"a2"}}`,
+		`{"op":"delete","id":"2"}`,
+	)
+	if err := openHistoryStore(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"op":"meta","lastId":2}` + "\n" + `{"op":"add","msg":{"id":"1","message":"a2"}}` + "\n"
+	if string(data) != want {
+		t.Errorf("compacted log = %q, want %q", data, want)
+	}
+
+	// a new message after a restart mustn't reuse the deleted message's ID
+	msg := map[string]string{"message": "c"}
+	recordMessage(msg)
+	if msg["id"] != "3" {
+		t.Errorf("new message got id %s, want 3", msg["id"])
+	}
+}
+
+func TestHistoryCompactsWhenMostlyGarbage(t *testing.T) {
+	resetHistory(t, map[string]interface{}{})
+	path := writeHistoryLog(t)
+	if err := openHistoryStore(path); err != nil {
+		t.Fatal(err)
+	}
+	msg := map[string]string{"user": "alice", "message": "a"}
+	recordMessage(msg)
+	for i := 0; i < 150; i++ {
+		if _, err := editMessage(msg["id"], "alice", "edit"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > 2*len(messageHistory)+100+1 {
+		t.Errorf("log has %d records for %d messages, it wasn't compacted", lines, len(messageHistory))
+	}
+
+	// and it still replays to the same thing
+	resetHistory(t, map[string]interface{}{})
+	if err := readHistoryLogLocked(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(messageHistory) != 1 || messageHistory[0]["message"] != "edit" {
+		t.Errorf("replayed %v", messageHistory)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	now := time.Now().UTC()
+	daysAgo := func(days int) string {
+		return now.Add(-time.Duration(days) * 24 * time.Hour).Format(time.RFC3339)
+	}
+	history := []map[string]string{
+		{"id": "1", "timestamp": daysAgo(40)},
+		{"id": "2", "timestamp": daysAgo(20)},
+		{"id": "3", "timestamp": daysAgo(5)},
+		{"id": "4", "timestamp": daysAgo(1)},
+	}
+
+	cases := []struct {
+		name    string
+		config  map[string]interface{}
+		wantIDs []string
+	}{
+		{"defaults drop after 30 days", map[string]interface{}{}, []string{"2", "3", "4"}},
+		{"count", map[string]interface{}{"historyMaxMessages": 2.0, "historyMaxAgeDays": 0.0}, []string{"3", "4"}},
+		{"age", map[string]interface{}{"historyMaxMessages": 0.0, "historyMaxAgeDays": 10.0}, []string{"3", "4"}},
+		{"count and age", map[string]interface{}{"historyMaxMessages": 1.0, "historyMaxAgeDays": 10.0}, []string{"4"}},
+		{"no limits", map[string]interface{}{"historyMaxMessages": 0.0, "historyMaxAgeDays": 0.0}, []string{"1", "2", "3", "4"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resetHistory(t, c.config)
+			messageHistory = append([]map[string]string(nil), history...)
+			reindexHistoryLocked()
+
+			dropped := applyRetentionLocked()
+			if got := historyIDs(); !reflect.DeepEqual(got, c.wantIDs) {
+				t.Errorf("ids = %v, want %v", got, c.wantIDs)
+			}
+			if dropped != (len(c.wantIDs) < len(history)) {
+				t.Errorf("applyRetentionLocked() = %v", dropped)
+			}
+			for i, id := range c.wantIDs {
+				if historyIndex[id] != i {
+					t.Errorf("historyIndex[%s] = %d, want %d", id, historyIndex[id], i)
+				}
+			}
+		})
+	}
+}
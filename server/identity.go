@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// usernames aren't authenticated, so the first client to join under a name is given a secret token
+// and has to send it back in later handshakes. only clients that do get that name's mailbox and
+// read markers, anyone else using the name is just a guest
+
+// a new random token, sent to the client once, only its hash is kept
+func newIdentityToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashIdentityToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// whether username has a token, caller must hold mailboxMutex
+func isRegisteredLocked(username string) bool {
+	_, registered := mailbox.Tokens[username]
+	return registered
+}
+
+// checks the token a client sent in its handshake, registering the name if nobody has it yet.
+// returns the new token when one was issued, which has to be sent to the client
+func checkIdentity(client *ClientInfo, token string) string {
+	if !client.Capabilities["identity"] {
+		return "" // older clients can't keep a token
+	}
+
+	mailboxMutex.Lock()
+	defer mailboxMutex.Unlock()
+
+	hash, registered := mailbox.Tokens[client.Username]
+	if registered {
+		if token == "" || subtle.ConstantTimeCompare([]byte(hashIdentityToken(token)), []byte(hash)) != 1 {
+			return ""
+		}
+		client.isVerified = true
+		mailbox.KnownUsers[client.Username] = time.Now().UTC().Format(time.RFC3339)
+		saveMailboxLocked()
+		return ""
+	}
+
+	issued, err := newIdentityToken()
+	if err != nil {
+		log.Println("Error making identity token:", err)
+		return ""
+	}
+	mailbox.Tokens[client.Username] = hashIdentityToken(issued)
+	// anything kept under this name from before tokens can't be tied to this client
+	delete(mailbox.Mail, client.Username)
+	delete(mailbox.LastRead, client.Username)
+	mailbox.KnownUsers[client.Username] = time.Now().UTC().Format(time.RFC3339)
+	saveMailboxLocked()
+	client.isVerified = true
+	return issued
+}
+
+// sends a newly issued token to its client, or tells a client why its mail isn't kept
+func sendIdentity(client *ClientInfo, issued string) {
+	if issued != "" {
+		sendToClient(client.Conn, map[string]string{
+			"type":  "identity",
+			"user":  client.Username,
+			"token": issued,
+		})
+		return
+	}
+	if client.isVerified {
+		return
+	}
+	notice := "Your client can't prove who you are, so DMs and mentions won't be kept for you while you're away."
+	if client.Capabilities["identity"] {
+		notice = fmt.Sprintf("%s is registered here by someone else, DMs and mentions for it won't be kept for you.", client.Username)
+	}
+	sendToClient(client.Conn, map[string]string{
+		"type":      "message",
+		"user":      "server",
+		"message":   notice,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
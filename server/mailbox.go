@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMailboxFile       = "mailbox.json"
+	defaultMailboxSize       = 50 // messages kept per offline user
+	defaultMailboxMaxAgeDays = 30
+)
+
+// something that happened while a user was away
+type mail struct {
+	Kind      string `json:"kind"` // "dm" or "mention"
+	From      string `json:"from"`
+	Message   string `json:"message"`
+	Color     string `json:"color,omitempty"`
+	Timestamp string `json:"timestamp"`
+	ID        string `json:"id,omitempty"` // message ID, for mentions
+}
+
+// what's kept in mailboxFile, everything we remember about users between visits
+type mailboxStore struct {
+	KnownUsers map[string]string           `json:"knownUsers"` // key: username, value: when they were last seen
+	Tokens     map[string]string           `json:"tokens"`     // key: username, value: hash of the identity token it was registered with
+	Mail       map[string][]mail           `json:"mail"`       // key: username
+	LastRead   map[string]map[string]int64 `json:"lastRead"`   // key: username, then room, value: last message ID they got
+}
+
+var mailbox = mailboxStore{
+	KnownUsers: make(map[string]string),
+	Tokens:     make(map[string]string),
+	Mail:       make(map[string][]mail),
+	LastRead:   make(map[string]map[string]int64),
+}
+var mailboxMutex sync.Mutex
+
+// where the mailbox is saved, empty keeps it in memory only
+var mailboxPath string
+
+// loads the known users and undelivered mail
+func openMailbox(path string) error {
+	mailboxMutex.Lock()
+	defer mailboxMutex.Unlock()
+
+	mailboxPath = path
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading mailbox: %w", err)
+	}
+	if err := json.Unmarshal(data, &mailbox); err != nil {
+		return fmt.Errorf("error decoding mailbox: %w", err)
+	}
+	if mailbox.KnownUsers == nil {
+		mailbox.KnownUsers = make(map[string]string)
+	}
+	if mailbox.Tokens == nil {
+		mailbox.Tokens = make(map[string]string) // mailboxes from before identity tokens
+	}
+	if mailbox.Mail == nil {
+		mailbox.Mail = make(map[string][]mail)
+	}
+	if mailbox.LastRead == nil {
+		mailbox.LastRead = make(map[string]map[string]int64) // mailboxes from before read markers
+	}
+	return nil
+}
+
+// writes the mailbox to disk, caller must hold mailboxMutex
+func saveMailboxLocked() {
+	if mailboxPath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(mailbox, "", "  ")
+	if err != nil {
+		log.Println("Error marshaling mailbox:", err)
+		return
+	}
+	// write then rename so a crash can't leave half a mailbox
+	tmpPath := mailboxPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		log.Println("Error writing mailbox:", err)
+		return
+	}
+	if err := os.Rename(tmpPath, mailboxPath); err != nil {
+		log.Println("Error writing mailbox:", err)
+	}
+}
+
+// the connected client with this username, nil if they're offline
+func findClient(username string) *ClientInfo {
+	var found *ClientInfo
+	clients.Range(func(key, value interface{}) bool {
+		client := value.(*ClientInfo)
+		if client.isApproved && client.Username == username {
+			found = client
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// puts mail in an offline user's mailbox, only users that have registered their name get one
+func storeMail(to string, m mail) error {
+	mailboxMutex.Lock()
+	defer mailboxMutex.Unlock()
+
+	if !isRegisteredLocked(to) {
+		return fmt.Errorf("%s hasn't registered here, so it can't be kept for them", to)
+	}
+	if len(mailbox.Mail[to]) >= configInt("mailboxSize", defaultMailboxSize) {
+		return fmt.Errorf("%s's mailbox is full", to)
+	}
+	mailbox.Mail[to] = append(mailbox.Mail[to], m)
+	saveMailboxLocked()
+	return nil
+}
+
+// hands over anything that came in while a user was away, if they proved the name is theirs,
+// mentions of messages from replayedFrom on were just sent with the history so they're skipped
+func deliverMail(client *ClientInfo, replayedFrom int64) {
+	if !client.isVerified {
+		return
+	}
+	mailboxMutex.Lock()
+	var waiting []mail
+	for _, m := range mailbox.Mail[client.Username] {
+		if id, err := strconv.ParseInt(m.ID, 10, 64); m.Kind == "mention" && err == nil && replayedFrom != 0 && id >= replayedFrom {
+			continue
+		}
+		waiting = append(waiting, m)
+	}
+	delete(mailbox.Mail, client.Username)
+	saveMailboxLocked()
+	mailboxMutex.Unlock()
+
+	if len(waiting) == 0 {
+		return
+	}
+	sendToClient(client.Conn, map[string]string{
+		"type":    "message",
+		"user":    "server",
+		"message": fmt.Sprintf("You have %d message(s) from while you were away:", len(waiting)),
+	})
+	for _, m := range waiting {
+		switch {
+		case !client.Capabilities["dm"]:
+			// older clients get a plain server message
+			prefix := "DM from " + m.From
+			if m.Kind == "mention" {
+				prefix = fmt.Sprintf("%s mentioned you in #%s", m.From, m.ID)
+			}
+			sendToClient(client.Conn, map[string]string{
+				"type":    "message",
+				"user":    "server",
+				"message": prefix + ": " + m.Message,
+			})
+		case m.Kind == "mention":
+			sendToClient(client.Conn, map[string]string{
+				"type":      "missedMention",
+				"id":        m.ID,
+				"user":      m.From,
+				"color":     m.Color,
+				"message":   m.Message,
+				"timestamp": m.Timestamp,
+			})
+		default:
+			sendToClient(client.Conn, map[string]string{
+				"type":      "dm",
+				"from":      m.From,
+				"to":        client.Username,
+				"color":     m.Color,
+				"message":   m.Message,
+				"timestamp": m.Timestamp,
+				"offline":   "true",
+			})
+		}
+		time.Sleep(10 * time.Millisecond) // same pacing as the history replay
+	}
+}
+
+// sends a direct message, or keeps it for later if the recipient is offline
+func sendDirectMessage(from *ClientInfo, to string, text string, color string) error {
+	if to == from.Username {
+		return errors.New("you can't DM yourself")
+	}
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	dm := map[string]string{
+		"type":      "dm",
+		"from":      from.Username,
+		"to":        to,
+		"color":     color,
+		"message":   text,
+		"timestamp": timestamp,
+	}
+
+	if target := findClient(to); target != nil {
+		if target.Capabilities["dm"] {
+			sendToClient(target.Conn, dm)
+		} else {
+			serverDmUser(fmt.Sprintf("DM from %s: %s", from.Username, text), to)
+		}
+	} else {
+		err := storeMail(to, mail{Kind: "dm", From: from.Username, Message: text, Color: color, Timestamp: timestamp})
+		if err != nil {
+			return err
+		}
+		dm["stored"] = "true" // tells the sender it'll be delivered later
+	}
+
+	// echo it back so the sender sees it in their chat
+	sendToClient(from.Conn, dm)
+	return nil
+}
+
+// matches @username as its own word, same rule the client highlights with
+func mentionRegexp(username string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(^|[^\w@])@` + regexp.QuoteMeta(username) + `([^\w]|$)`)
+}
+
+// keeps a copy of a chat message for every offline user it mentions
+func mailMentions(message map[string]string) {
+	text := strings.ToLower(message["message"])
+	if !strings.Contains(text, "@") {
+		return
+	}
+
+	mailboxMutex.Lock()
+	var mentioned []string
+	for user := range mailbox.Tokens {
+		if user != message["user"] && strings.Contains(text, "@"+strings.ToLower(user)) && mentionRegexp(user).MatchString(message["message"]) {
+			mentioned = append(mentioned, user)
+		}
+	}
+	mailboxMutex.Unlock()
+
+	for _, user := range mentioned {
+		if findClient(user) != nil {
+			continue // they saw it live
+		}
+		// a full mailbox just means this mention isn't kept
+		storeMail(user, mail{
+			Kind:      "mention",
+			From:      message["user"],
+			Message:   message["message"],
+			Color:     message["color"],
+			Timestamp: message["timestamp"],
+			ID:        message["id"],
+		})
+	}
+}
+
+// drops undelivered mail older than mailboxMaxAgeDays, returns how many went
+func expireMail() int {
+	maxAgeDays := configInt("mailboxMaxAgeDays", defaultMailboxMaxAgeDays)
+	if maxAgeDays == 0 {
+		return 0
+	}
+	cutoff := time.Now().Add(-time.Duration(maxAgeDays) * 24 * time.Hour)
+
+	mailboxMutex.Lock()
+	defer mailboxMutex.Unlock()
+	expired := 0
+	for user, waiting := range mailbox.Mail {
+		kept := waiting[:0:0]
+		for _, m := range waiting {
+			if ts, err := time.Parse(time.RFC3339, m.Timestamp); err == nil && ts.Before(cutoff) {
+				expired++
+				continue
+			}
+			kept = append(kept, m)
+		}
+		if len(kept) == 0 {
+			delete(mailbox.Mail, user)
+		} else {
+			mailbox.Mail[user] = kept
+		}
+	}
+	if expired > 0 {
+		saveMailboxLocked()
+	}
+	return expired
+}
@@ -3,49 +3,58 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 	"unsafe"
 
 	goaway "github.com/TwiN/go-away" // for profanity check
 )
 
 type ClientInfo struct {
-	Conn          net.Conn    // connection to the client
-	Username      string      // username of the client
-	IP            string      // IP address of the client
-	isApproved    bool        // whether the client has been approved after handshake (used for passwordProtected)
-	MsgTimestamps []time.Time // timestamps of the last 10 messages sent by the client
+	Conn          net.Conn        // connection to the client
+	Username      string          // username of the client
+	IP            string          // IP address of the client
+	isApproved    bool            // whether the client has been approved after handshake (used for passwordProtected)
+	MsgTimestamps []time.Time     // timestamps of the last 10 messages sent by the client
+	Capabilities  map[string]bool // optional protocol features the client said it supports during handshake
+	isModerator   bool            // whether the client gave the moderatorPassword during handshake
+	isVerified    bool            // whether the client sent the identity token its username was registered with
+	isTyping      bool            // whether the client last told us it's typing
+	lastTypingAt  time.Time       // when the client last sent a typing start, used for throttling
+	lastSearchAt  time.Time       // when the client last searched, used for throttling
+	lastSeenID    atomic.Int64    // newest message ID sent to the client, saved as their read marker when they leave
 }
 
+// optional protocol features this server supports, advertised in the handshake
+var serverCapabilities = []string{"typing", "threads", "edit", "reactions", "history", "search", "dm", "unread", "purge", "roster", "identity"}
+
+// min time between typing start events we fan out per client
+const typingThrottle = 1 * time.Second
+
+// min time between searches per client, each one scans the whole history
+const searchThrottle = 1 * time.Second
+
 // Change clients to store ClientInfo
 var clients sync.Map // key: net.Conn, value: *ClientInfo
 var serverConfig map[string]interface{}
 
-// store 10 latest messages
+// stored messages, oldest first, see history.go
 var messageHistory []map[string]string
 var messageHistoryMutex sync.Mutex
 
 // ip ban table
 var ipBanTable sync.Map // key: string (IP address), value: bool (banned or not)
 
-var ansiColors = map[string]string{
-	"reset":   "\033[0m",
-	"red":     "\033[31m",
-	"green":   "\033[32m",
-	"yellow":  "\033[33m",
-	"blue":    "\033[34m",
-	"magenta": "\033[35m",
-	"cyan":    "\033[36m",
-	"white":   "\033[37m",
-}
-
 func handleClient(conn net.Conn, handshakeDone chan struct{}) {
 	defer conn.Close()
 	fmt.Println("Client connected:", conn.RemoteAddr())
@@ -58,15 +67,33 @@ func handleClient(conn net.Conn, handshakeDone chan struct{}) {
 	}
 	clients.Store(conn, clientInfo)
 
-	buffer := make([]byte, 1024)
+	// clients can send several messages back to back (e.g. typing events), so decode them as a stream
+	decoder := json.NewDecoder(conn)
 	for {
-		n, err := conn.Read(buffer)
-		if err != nil {
+		var jsonMsg map[string]string
+		if err := decoder.Decode(&jsonMsg); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				fmt.Println("Error parsing JSON:", err)
+				continue
+			}
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				fmt.Println("Invalid JSON received")
+			}
+
 			// handle general disconnects
 			if val, ok := clients.Load(conn); ok {
 				// get username from ClientInfo
 				client := val.(*ClientInfo)
 				fmt.Printf("Client disconnected: %s (%s)\n", client.Username, conn.RemoteAddr())
+				if client.Username != "" {
+					logChatEvent("leave", client, chatLogRecord{})
+					saveReadMarker(client, defaultRoom, client.lastSeenID.Load())
+				}
+				if client.isTyping {
+					broadcastTyping(client, "stop")
+				}
 				// broadcast that user has left
 				broadcastMessage(map[string]string{
 					"type":    "message",
@@ -77,18 +104,12 @@ func handleClient(conn net.Conn, handshakeDone chan struct{}) {
 				fmt.Println("Client disconnected:", conn.RemoteAddr())
 			}
 			clients.Delete(conn)
+			broadcastRoster()
 			return
 		}
 
-		var jsonMsg map[string]string
-		if !json.Valid(buffer[:n]) {
-			fmt.Println("Invalid JSON received")
-			continue
-		}
-		if err := json.Unmarshal(buffer[:n], &jsonMsg); err != nil {
-			fmt.Println("Error parsing JSON:", err)
-			return
-		}
+		// escape sequences in names or messages would end up on everyone's terminal
+		sanitizeClientMessage(jsonMsg)
 
 		// handshake process on new connection
 		if jsonMsg["type"] == "handshake" {
@@ -152,7 +173,7 @@ func handleClient(conn net.Conn, handshakeDone chan struct{}) {
 			})
 
 			// check if username is between 3-20 characters
-			if len(jsonMsg["user"]) < 3 || len(jsonMsg["user"]) > 20 {
+			if n := utf8.RuneCountInString(jsonMsg["user"]); n < 3 || n > 20 {
 				fmt.Println("Username must be between 3 and 20 characters:", jsonMsg["user"])
 				conn.Write([]byte("Username must be between 3 and 20 characters"))
 				clients.Delete(conn)
@@ -186,10 +207,16 @@ func handleClient(conn net.Conn, handshakeDone chan struct{}) {
 			}
 
 			// atp the client checks out, approve the client
+			clientInfo.Capabilities = parseCapabilities(jsonMsg["capabilities"])
+			if modPassword, ok := serverConfig["moderatorPassword"].(string); ok && modPassword != "" {
+				clientInfo.isModerator = jsonMsg["moderatorPassword"] == modPassword
+			}
 			clientInfo.isApproved = true
 			fmt.Println("Client approved:", jsonMsg["user"])
 			// Set username after handshake
 			clientInfo.Username = jsonMsg["user"]
+			logChatEvent("join", clientInfo, chatLogRecord{})
+			issuedToken := checkIdentity(clientInfo, jsonMsg["identityToken"])
 
 			// Signal handshake completion
 			select {
@@ -201,15 +228,21 @@ func handleClient(conn net.Conn, handshakeDone chan struct{}) {
 			fmt.Println("Handshake received from client:", jsonMsg["user"])
 
 			// send message history here if enabled
+			var replayedFrom int64
 			if serverConfig["sendMessageHistory"].(bool) {
-				sendMessageHistory(conn)
+				replayedFrom = sendMessageHistory(clientInfo)
 			}
 
+			// then anything that came in for them while they were away
+			sendIdentity(clientInfo, issuedToken)
+			deliverMail(clientInfo, replayedFrom)
+
 			broadcastMessage(map[string]string{
 				"type":    "message",
 				"user":    "server",
 				"message": fmt.Sprintf("%s has joined the chat", jsonMsg["user"]),
 			})
+			broadcastRoster()
 			var clientCount int
 			serverName := serverConfig["serverName"].(string)
 			clients.Range(func(key, value interface{}) bool {
@@ -248,45 +281,227 @@ func handleClient(conn net.Conn, handshakeDone chan struct{}) {
 				continue
 			}
 
-			// check if message exceeds character limit, if so, trim
-			charLimit := int(serverConfig["messageCharLimit"].(float64))
-			if len(jsonMsg["message"]) > charLimit {
-				jsonMsg["message"] = jsonMsg["message"][:charLimit]
-				// message should already be displayed clientside
-			}
+			jsonMsg["message"] = cleanMessageText(jsonMsg["message"])
 
-			// profanity check if enabled in config
-			if serverConfig["profanityCheck"].(bool) {
-				if goaway.IsProfane(jsonMsg["message"]) {
-					jsonMsg["message"] = goaway.Censor(jsonMsg["message"])
+			// the server decides who sent it and where it goes in the history, not the client
+			message := map[string]string{
+				"type":    "message",
+				"user":    clientInfo.Username,
+				"message": jsonMsg["message"],
+			}
+			if color, ok := jsonMsg["color"]; ok {
+				message["color"] = color
+			}
+			if replyTo := jsonMsg["replyTo"]; replyTo != "" {
+				parentID, ok := parseMessageID(replyTo)
+				if !ok || !attachReply(message, parentID) {
+					serverDmUser(fmt.Sprintf("Message #%s not found, it may be too old to reply to.", replyTo), clientInfo.Username)
+					continue
 				}
 			}
 
-			fmt.Printf("Received message from %s: %s\n", jsonMsg["user"], jsonMsg["message"])
+			fmt.Printf("Received message from %s: %s\n", message["user"], message["message"])
 
-			if config, ok := serverConfig["logMessages"].(bool); ok && config {
-				// log messages to a file
-				logFile, err := os.OpenFile("chat.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-				if err != nil {
-					log.Println("Error opening log file:", err)
-				} else {
-					defer logFile.Close()
-					logMessage := fmt.Sprintf("%s [%s]: %s\n", time.Now().Format("2006-01-02 15:04:05"), jsonMsg["user"], jsonMsg["message"])
-					if _, err := logFile.WriteString(logMessage); err != nil {
-						log.Println("Error writing to log file:", err)
-					}
-				}
-			}
+			broadcastMessage(message) // assigns the ID
+			logChatEvent("message", clientInfo, chatLogRecord{ID: message["id"], Message: message["message"], Color: message["color"]})
+			mailMentions(message)
 
-			broadcastMessage(jsonMsg)
+		} else if jsonMsg["type"] == "edit" { // when a user edits one of their messages
+			if !clientInfo.isApproved {
+				continue
+			}
+			id, ok := parseMessageID(jsonMsg["id"])
+			if !ok || jsonMsg["message"] == "" {
+				continue
+			}
+			if isRateLimited(clientInfo) {
+				serverDmUser("You are sending messages too fast, please wait a bit.", clientInfo.Username)
+				continue
+			}
+			edited, err := editMessage(id, clientInfo.Username, cleanMessageText(jsonMsg["message"]))
+			if err != nil {
+				serverDmUser(fmt.Sprintf("Edit failed: %v", err), clientInfo.Username)
+				continue
+			}
+			fmt.Printf("%s edited message #%s: %s\n", clientInfo.Username, id, edited["message"])
+			broadcastToCapable(map[string]string{
+				"type":     "edit",
+				"user":     edited["user"],
+				"id":       id,
+				"message":  edited["message"],
+				"editedAt": edited["editedAt"],
+			}, "edit", nil)
+		} else if jsonMsg["type"] == "delete" { // when a user (or moderator) deletes a message
+			if !clientInfo.isApproved {
+				continue
+			}
+			id, ok := parseMessageID(jsonMsg["id"])
+			if !ok {
+				continue
+			}
+			if err := deleteMessage(id, clientInfo.Username, clientInfo.isModerator); err != nil {
+				serverDmUser(fmt.Sprintf("Delete failed: %v", err), clientInfo.Username)
+				continue
+			}
+			fmt.Printf("%s deleted message #%s\n", clientInfo.Username, id)
+			broadcastDelete(id, clientInfo.Username)
+		} else if jsonMsg["type"] == "react" { // when a user toggles a reaction on a message
+			if !clientInfo.isApproved {
+				continue
+			}
+			id, ok := parseMessageID(jsonMsg["id"])
+			if !ok {
+				continue
+			}
+			if isRateLimited(clientInfo) {
+				serverDmUser("You are sending messages too fast, please wait a bit.", clientInfo.Username)
+				continue
+			}
+			emoji, err := normalizeReaction(jsonMsg["reaction"])
+			if err != nil {
+				serverDmUser(fmt.Sprintf("Reaction failed: %v", err), clientInfo.Username)
+				continue
+			}
+			reactions, err := toggleReaction(id, clientInfo.Username, emoji)
+			if err != nil {
+				serverDmUser(fmt.Sprintf("Reaction failed: %v", err), clientInfo.Username)
+				continue
+			}
+			broadcastToCapable(map[string]string{
+				"type":      "reaction",
+				"user":      clientInfo.Username,
+				"id":        id,
+				"reaction":  emoji,
+				"reactions": reactions,
+			}, "reactions", nil)
+		} else if jsonMsg["type"] == "historyRequest" { // when a user scrolls further back than they have
+			if !clientInfo.isApproved {
+				continue
+			}
+			limit, err := strconv.Atoi(jsonMsg["limit"])
+			if err != nil || limit < 1 {
+				limit = defaultHistorySendCount
+			}
+			if limit > maxHistoryPageSize {
+				limit = maxHistoryPageSize
+			}
+			before := ""
+			if id, ok := parseMessageID(jsonMsg["before"]); ok {
+				before = id
+			}
+			page, hasMore := messagesBefore(before, limit)
+			for _, msg := range page {
+				historyMsg := copyMessage(msg)
+				historyMsg["type"] = "historyMessage"
+				sendToClient(conn, historyMsg)
+			}
+			pageEnd := map[string]string{
+				"type":    "historyEnd",
+				"before":  before,
+				"count":   strconv.Itoa(len(page)),
+				"hasMore": strconv.FormatBool(hasMore),
+			}
+			if len(page) > 0 {
+				pageEnd["oldest"] = page[0]["id"]
+			}
+			sendToClient(conn, pageEnd)
+		} else if jsonMsg["type"] == "dm" { // when a user messages someone directly
+			if !clientInfo.isApproved {
+				continue
+			}
+			if isRateLimited(clientInfo) {
+				serverDmUser("You are sending messages too fast, please wait a bit.", clientInfo.Username)
+				continue
+			}
+			to := strings.TrimPrefix(jsonMsg["to"], "@")
+			text := cleanMessageText(jsonMsg["message"])
+			if to == "" || text == "" {
+				continue
+			}
+			if err := sendDirectMessage(clientInfo, to, text, jsonMsg["color"]); err != nil {
+				serverDmUser(fmt.Sprintf("Couldn't send your DM: %v", err), clientInfo.Username)
+			}
+		} else if jsonMsg["type"] == "searchRequest" { // when a user runs //search
+			if !clientInfo.isApproved {
+				continue
+			}
+			searchEnd := map[string]string{
+				"type":  "searchEnd",
+				"query": jsonMsg["query"],
+			}
+			if time.Since(clientInfo.lastSearchAt) < searchThrottle {
+				searchEnd["error"] = "You're searching too fast, try again in a second."
+				sendToClient(conn, searchEnd)
+				continue
+			}
+			clientInfo.lastSearchAt = time.Now()
 
+			query, err := parseSearchQuery(jsonMsg["query"])
+			if err != nil {
+				searchEnd["error"] = "Invalid search: " + err.Error()
+				sendToClient(conn, searchEnd)
+				continue
+			}
+			results, truncated := searchMessages(query, maxSearchResults)
+			for _, msg := range results {
+				msg["type"] = "searchResult"
+				sendToClient(conn, msg)
+			}
+			searchEnd["count"] = strconv.Itoa(len(results))
+			searchEnd["truncated"] = strconv.FormatBool(truncated)
+			sendToClient(conn, searchEnd)
+		} else if jsonMsg["type"] == "threadRequest" { // when a user wants a whole thread
+			if !clientInfo.isApproved {
+				continue
+			}
+			rootID, ok := parseMessageID(jsonMsg["id"])
+			if !ok {
+				continue
+			}
+			thread := threadMessages(rootID)
+			for _, msg := range thread {
+				threadMsg := copyMessage(msg)
+				threadMsg["type"] = "threadMessage"
+				sendToClient(conn, threadMsg)
+			}
+			sendToClient(conn, map[string]string{
+				"type":  "threadEnd",
+				"id":    rootID,
+				"count": fmt.Sprintf("%d", len(thread)),
+			})
+		} else if jsonMsg["type"] == "typing" { // when a user starts or stops typing
+			if !clientInfo.isApproved {
+				continue
+			}
+			state := jsonMsg["state"]
+			if state != "start" && state != "stop" {
+				continue
+			}
+			if state == "start" {
+				// drop starts that come in faster than we're willing to fan them out
+				if time.Since(clientInfo.lastTypingAt) < typingThrottle {
+					continue
+				}
+				clientInfo.lastTypingAt = time.Now()
+			} else if !clientInfo.isTyping {
+				continue // nothing to stop
+			}
+			clientInfo.isTyping = state == "start"
+			broadcastTyping(clientInfo, state)
 		} else if jsonMsg["type"] == "ping" {
-			// handle ping message
-			fmt.Println("Received ping from:", jsonMsg["user"])
+			// handle ping message, the status bar's latency pings come every few seconds so they're not logged
+			quiet := jsonMsg["id"] == "status"
+			if !quiet {
+				fmt.Println("Received ping from:", jsonMsg["user"])
+			}
 			// send a pong response
 			pongMsg := map[string]string{
 				"type": "pong",
 			}
+			// echo the id back so a client can tell its pings apart
+			if id, ok := jsonMsg["id"]; ok {
+				pongMsg["id"] = id
+			}
 			jsonData, err := json.Marshal(pongMsg)
 			if err != nil {
 				log.Println("Error marshaling pong message:", err)
@@ -297,13 +512,83 @@ func handleClient(conn net.Conn, handshakeDone chan struct{}) {
 				log.Println("Error sending pong message:", err)
 				continue
 			}
-			fmt.Println("Sent pong response to client:", jsonMsg["user"])
+			if !quiet {
+				fmt.Println("Sent pong response to client:", jsonMsg["user"])
+			}
 		} else {
 			fmt.Println("Received non-message type:", jsonMsg["type"])
 		}
 	}
 }
 
+// parses the comma separated capability list a client sends during handshake
+func parseCapabilities(list string) map[string]bool {
+	capabilities := make(map[string]bool)
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			capabilities[c] = true
+		}
+	}
+	return capabilities
+}
+
+// sends a message to every approved client that supports capability, except skip
+func broadcastToCapable(message map[string]string, capability string, skip net.Conn) {
+	jsonMsg, err := json.Marshal(message)
+	if err != nil {
+		log.Println("Error marshaling JSON:", err)
+		return
+	}
+	clients.Range(func(key, value interface{}) bool {
+		client := value.(*ClientInfo)
+		if !client.isApproved || client.Conn == skip || !client.Capabilities[capability] {
+			return true
+		}
+		if _, err := client.Conn.Write(jsonMsg); err != nil {
+			log.Println("Error sending message to client:", err)
+		}
+		return true
+	})
+}
+
+// tells clients that a message was deleted, so they can remove it from their screen
+func broadcastDelete(id string, deletedBy string) {
+	broadcastToCapable(map[string]string{
+		"type":      "delete",
+		"user":      "server",
+		"id":        id,
+		"deletedBy": deletedBy,
+	}, "edit", nil)
+}
+
+// tells everyone else that client started or stopped typing
+func broadcastTyping(client *ClientInfo, state string) {
+	broadcastToCapable(map[string]string{
+		"type":  "typing",
+		"user":  client.Username,
+		"state": state,
+	}, "typing", client.Conn)
+}
+
+// applies the character limit and profanity filter to message text
+func cleanMessageText(text string) string {
+	// check if message exceeds character limit, if so, trim
+	// counted in characters rather than bytes, so a limit never cuts one in half
+	charLimit := int(serverConfig["messageCharLimit"].(float64))
+	if utf8.RuneCountInString(text) > charLimit {
+		text = string([]rune(text)[:charLimit])
+		// message should already be displayed clientside
+	}
+
+	// profanity check if enabled in config
+	if serverConfig["profanityCheck"].(bool) {
+		if goaway.IsProfane(text) {
+			text = goaway.Censor(text)
+		}
+	}
+	return text
+}
+
 func isRateLimited(client *ClientInfo) bool {
 	const rateLimitWindow = 5 * time.Second
 	const rateLimitCount = 10 // max of 10 messages in 5 seconds
@@ -334,9 +619,10 @@ func serverDmUser(message string, user string) {
 		client := value.(*ClientInfo)
 		if client.Username == user {
 			jsonMsg := map[string]string{
-				"type":    "message",
-				"user":    "server",
-				"message": message,
+				"type":      "message",
+				"user":      "server",
+				"message":   message,
+				"timestamp": time.Now().UTC().Format(time.RFC3339),
 			}
 			jsonData, err := json.Marshal(jsonMsg)
 			if err != nil {
@@ -354,49 +640,24 @@ func serverDmUser(message string, user string) {
 	})
 }
 
-func validateAnsi(color string) string {
-	if ansiColor, ok := ansiColors[color]; ok {
-		return ansiColor
-	}
-	fmt.Println("Invalid ANSI color:", color)
-	return ansiColors["blue"] // return blue color if invalid
-}
-
-func sendMessageHistory(conn net.Conn) {
-	messageHistoryMutex.Lock()
-	defer messageHistoryMutex.Unlock()
-
-	if len(messageHistory) == 0 {
-		return // no messages to send
+// marshals a message and writes it to a single connection
+func sendToClient(conn net.Conn, message map[string]string) error {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		log.Println("Error marshaling message:", err)
+		return err
 	}
-
-	for _, msg := range messageHistory {
-		jsonData, err := json.Marshal(msg)
-		if err != nil {
-			log.Println("Error marshaling message history:", err)
-			continue
-		}
-		_, err = conn.Write(jsonData)
-		if err != nil {
-			log.Println("Error sending message history to client:", err)
-			break
-		}
-		// small delay to avoid messing up the client
-		time.Sleep(10 * time.Millisecond)
+	if _, err := conn.Write(jsonData); err != nil {
+		log.Println("Error sending message to client:", err)
+		return err
 	}
+	return nil
 }
 
 func broadcastMessage(message map[string]string) {
-	// store message in history
+	// give chat messages an ID and timestamp, and store them in history
 	if message["type"] == "message" {
-		if config, ok := serverConfig["sendMessageHistory"].(bool); ok && config {
-			messageHistoryMutex.Lock()
-			messageHistory = append(messageHistory, message)
-			if len(messageHistory) > 10 {
-				messageHistory = messageHistory[1:] // keep only the latest 10
-			}
-			messageHistoryMutex.Unlock()
-		}
+		recordMessage(message)
 	}
 
 	clients.Range(func(key, value interface{}) bool {
@@ -411,24 +672,12 @@ func broadcastMessage(message map[string]string) {
 			return false
 		}
 
-		// validate that the "color" field is a valid ANSI color
-		if color, ok := message["color"]; ok {
-			jsonMsg = []byte(fmt.Sprintf(`{"user": "%s", "message": "%s", "type": "%s", "color": "%s"}`, message["user"], message["message"], message["type"], validateAnsi(color)))
-		} else {
-			jsonMsg = []byte(fmt.Sprintf(`{"user": "%s", "message": "%s", "type": "%s", "color": "%s"}`, message["user"], message["message"], message["type"], ansiColors["reset"]))
-		}
-
-		jsonMsg, err = json.Marshal(message)
-		if err != nil {
-			log.Println("Error marshaling JSON:", err)
-			return false
-		}
-
 		_, err = conn.Write(jsonMsg)
 		if err != nil {
 			log.Println("Error sending message to client:", err)
 			return false // stop iteration if error occurs
 		}
+		markSeen(clientInfo, message)
 		return true // continue iterating
 	})
 }
@@ -455,6 +704,7 @@ func sendHandshake(conn net.Conn) error {
 		"serverName":        serverConfig["serverName"].(string),
 		"messageCharLimit":  fmt.Sprintf("%d", int(serverConfig["messageCharLimit"].(float64))),
 		"passwordProtected": fmt.Sprintf("%t", serverConfig["passwordProtected"].(bool)),
+		"capabilities":      strings.Join(serverCapabilities, ","),
 	}
 
 	jsonMsg, err := json.Marshal(handshakeMsg)
@@ -471,6 +721,14 @@ func sendHandshake(conn net.Conn) error {
 	return nil
 }
 
+// reads an optional whole number from the config, falling back to def if it's missing
+func configInt(key string, def int) int {
+	if val, ok := serverConfig[key].(float64); ok {
+		return int(val)
+	}
+	return def
+}
+
 // validates the server configuration
 func configValidate(config map[string]interface{}) (string, bool) {
 	// validate the config map
@@ -487,7 +745,7 @@ func configValidate(config map[string]interface{}) (string, bool) {
 
 	// serverName check
 	if serverName, ok := config["serverName"].(string); ok {
-		if len(serverName) > 25 {
+		if utf8.RuneCountInString(serverName) > 25 {
 			configValidateResponse += "serverName must be under 25 chars\n"
 			isConfigOk = false
 		}
@@ -532,6 +790,70 @@ func configValidate(config map[string]interface{}) (string, bool) {
 		isConfigOk = false
 	}
 
+	// history checks, optional for older configs
+	if val, exists := config["historyFile"]; exists {
+		if _, ok := val.(string); !ok {
+			configValidateResponse += "historyFile must be a string\n"
+			isConfigOk = false
+		}
+	}
+	for _, key := range []string{"historyMaxMessages", "historyMaxAgeDays", "historySendCount"} {
+		if val, exists := config[key]; exists {
+			if n, ok := val.(float64); !ok || n < 0 || n != float64(int(n)) {
+				configValidateResponse += key + " must be a whole number, 0 or more\n"
+				isConfigOk = false
+			}
+		}
+	}
+
+	// chat log checks, optional for older configs
+	if val, exists := config["logFile"]; exists {
+		if path, ok := val.(string); !ok || path == "" {
+			configValidateResponse += "logFile must be a non-empty string\n"
+			isConfigOk = false
+		}
+	}
+	if val, exists := config["logMaxSizeMB"]; exists {
+		if n, ok := val.(float64); !ok || n < 1 || n != float64(int(n)) {
+			configValidateResponse += "logMaxSizeMB must be a whole number, 1 or more\n"
+			isConfigOk = false
+		}
+	}
+	if val, exists := config["logRetentionDays"]; exists {
+		if n, ok := val.(float64); !ok || n < 0 || n != float64(int(n)) {
+			configValidateResponse += "logRetentionDays must be a whole number, 0 or more\n"
+			isConfigOk = false
+		}
+	}
+
+	// mailbox checks, optional for older configs
+	if val, exists := config["mailboxFile"]; exists {
+		if _, ok := val.(string); !ok {
+			configValidateResponse += "mailboxFile must be a string\n"
+			isConfigOk = false
+		}
+	}
+	if val, exists := config["mailboxSize"]; exists {
+		if n, ok := val.(float64); !ok || n < 1 || n != float64(int(n)) {
+			configValidateResponse += "mailboxSize must be a whole number, 1 or more\n"
+			isConfigOk = false
+		}
+	}
+	if val, exists := config["mailboxMaxAgeDays"]; exists {
+		if n, ok := val.(float64); !ok || n < 0 || n != float64(int(n)) {
+			configValidateResponse += "mailboxMaxAgeDays must be a whole number, 0 or more\n"
+			isConfigOk = false
+		}
+	}
+
+	// moderatorPassword check, optional for older configs
+	if val, exists := config["moderatorPassword"]; exists {
+		if _, ok := val.(string); !ok {
+			configValidateResponse += "moderatorPassword must be a string\n"
+			isConfigOk = false
+		}
+	}
+
 	return configValidateResponse, isConfigOk
 }
 
@@ -545,12 +867,23 @@ func loadConfig() map[string]interface{} {
 			defaultConfig := map[string]interface{}{
 				"port":               9076.0, // make sure its float64
 				"serverName":         "an tchat server",
-				"messageCharLimit":   180.0, // character limit for messages
-				"logMessages":        false, // whether to log messages to a file
-				"passwordProtected":  false, // whether the server is password protected
-				"serverPassword":     "",    // server password, if empty, passwordProtected will be set to false
-				"sendMessageHistory": true,  // whether to send message history to new clients
-				"profanityCheck":     true,  // whether to enable profanity check
+				"messageCharLimit":   180.0,                              // character limit for messages
+				"logMessages":        false,                              // whether to log messages and join/leave/kick/ban events to a file
+				"logFile":            defaultLogFile,                     // JSON Lines chat log, rotated segments are gzipped next to it
+				"logMaxSizeMB":       float64(defaultLogMaxSizeMB),       // rotate the chat log once it gets this big (it's also rotated daily)
+				"logRetentionDays":   float64(defaultLogRetentionDays),   // delete rotated chat logs older than this, 0 keeps them forever
+				"mailboxFile":        defaultMailboxFile,                 // DMs and mentions kept for offline users, empty keeps them in memory only
+				"mailboxSize":        float64(defaultMailboxSize),        // max messages kept per offline user
+				"mailboxMaxAgeDays":  float64(defaultMailboxMaxAgeDays),  // undelivered messages older than this are dropped, 0 keeps them until delivered
+				"passwordProtected":  false,                              // whether the server is password protected
+				"serverPassword":     "",                                 // server password, if empty, passwordProtected will be set to false
+				"sendMessageHistory": true,                               // whether to send message history to new clients
+				"profanityCheck":     true,                               // whether to enable profanity check
+				"moderatorPassword":  "",                                 // clients that send this can delete anyone's messages, empty disables moderators
+				"historyFile":        defaultHistoryFile,                 // where message history is kept between restarts, empty keeps it in memory only
+				"historyMaxMessages": float64(defaultHistoryMaxMessages), // max messages kept in history, 0 for no limit
+				"historyMaxAgeDays":  float64(defaultHistoryMaxAgeDays),  // messages older than this are dropped, 0 keeps them forever
+				"historySendCount":   float64(defaultHistorySendCount),   // messages sent to clients when they join
 			}
 			file, err := os.Create(configFile)
 			if err != nil {
@@ -591,15 +924,49 @@ func handleServerCommand(cmdLine string) {
 	}
 	switch args[0] {
 	case "//clearchat":
-		messageHistoryMutex.Lock()
-		messageHistory = nil
-		messageHistoryMutex.Unlock()
+		clearHistory()
+		logChatEvent("clear", nil, chatLogRecord{By: "server"})
 		broadcastMessage(map[string]string{
 			"type":    "clearChat",
 			"user":    "server",
 			"message": "Chat history has been cleared by the server.",
 		})
 		fmt.Println("Chat cleared.")
+	case "//purge":
+		if len(args) < 3 || (args[1] != "user" && args[1] != "since") {
+			fmt.Println("Usage: //purge user <username> or //purge since <time> (2h, 3d, YYYY-MM-DD or RFC 3339)")
+			return
+		}
+		var filter purgeFilter
+		if args[1] == "user" {
+			filter.user = args[2]
+		} else {
+			since, err := parsePurgeTime(args[2])
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			filter.since = since
+		}
+		result, err := purgeMessages(filter)
+		if err != nil {
+			fmt.Println("Error purging chat logs:", err)
+		}
+		logChatEvent("purge", nil, chatLogRecord{User: filter.user, Message: filter.String(), By: "server"})
+		broadcastPurge(filter, result.ids)
+		fmt.Printf("Purged %s: %d from history, %d from chat logs, %d from mailboxes.\n", filter, len(result.ids), result.logged, result.mailbox)
+	case "//retention":
+		printRetentionPolicy()
+		history, mail := applyRetention()
+		if history {
+			fmt.Println("Dropped old messages from the history.")
+		}
+		if mail > 0 {
+			fmt.Printf("Expired %d undelivered messages.\n", mail)
+		}
+		if !history && mail == 0 {
+			fmt.Println("Nothing was old enough to drop.")
+		}
 	case "//kick":
 		if len(args) < 2 {
 			fmt.Println("Usage: //kick <username>")
@@ -610,6 +977,7 @@ func handleServerCommand(cmdLine string) {
 		clients.Range(func(key, value interface{}) bool {
 			c := value.(*ClientInfo)
 			if c.Username == username {
+				logChatEvent("kick", c, chatLogRecord{By: "server"})
 				c.Conn.Close()
 				kicked = true
 				return false
@@ -637,6 +1005,22 @@ func handleServerCommand(cmdLine string) {
 			"user":    "server",
 			"message": message,
 		})
+	case "//delete":
+		if len(args) < 2 {
+			fmt.Println("Usage: //delete <id>")
+			return
+		}
+		id, ok := parseMessageID(strings.TrimPrefix(args[1], "#"))
+		if !ok {
+			fmt.Println("Invalid message ID:", args[1])
+			return
+		}
+		if err := deleteMessage(id, "server", true); err != nil {
+			fmt.Println("Delete failed:", err)
+			return
+		}
+		broadcastDelete(id, "server")
+		fmt.Printf("Message #%s deleted.\n", id)
 	case "//ban": // TODO: write bans to a json file for persistence
 		if len(args) < 2 {
 			fmt.Println("Usage: //ban <user>")
@@ -652,6 +1036,7 @@ func handleServerCommand(cmdLine string) {
 				if _, exists := ipBanTable.Load(ip); !exists {
 					ipBanTable.Store(ip, true)
 					banned = true
+					logChatEvent("ban", c, chatLogRecord{By: "server"})
 					c.Conn.Close()
 					clients.Delete(c.Conn)
 					broadcastMessage(map[string]string{
@@ -675,6 +1060,9 @@ func handleServerCommand(cmdLine string) {
 
 // server startup
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
 
 	// load up server config
 	serverConfig = loadConfig()
@@ -690,6 +1078,36 @@ func main() {
 	// set process name
 	SetProcessName(serverConfig["serverName"].(string))
 
+	// load message history from disk so restarts don't wipe the conversation
+	historyFile := defaultHistoryFile
+	if path, ok := serverConfig["historyFile"].(string); ok {
+		historyFile = path
+	}
+	if err := openHistoryStore(historyFile); err != nil {
+		log.Fatal("Error loading message history:", err)
+	}
+	go enforceRetention()
+
+	mailboxFile := defaultMailboxFile
+	if path, ok := serverConfig["mailboxFile"].(string); ok {
+		mailboxFile = path
+	}
+	if err := openMailbox(mailboxFile); err != nil {
+		log.Fatal("Error loading mailbox:", err)
+	}
+
+	if serverConfig["logMessages"].(bool) {
+		logFile := defaultLogFile
+		if path, ok := serverConfig["logFile"].(string); ok {
+			logFile = path
+		}
+		var err error
+		chatLog, err = openChatLog(logFile, configInt("logMaxSizeMB", defaultLogMaxSizeMB), configInt("logRetentionDays", defaultLogRetentionDays))
+		if err != nil {
+			log.Fatal("Error opening chat log:", err)
+		}
+	}
+
 	// start up a tcp server
 	port := 9076 // default port
 	if p, ok := serverConfig["port"].(float64); ok {
@@ -736,8 +1154,9 @@ func main() {
 			continue
 		}
 
-		// handshakeDone channel to signal handshake completion
-		handshakeDone := make(chan struct{})
+		// handshakeDone channel to signal handshake completion, buffered so the
+		// signal isn't lost if the handshake finishes before the timeout goroutine is waiting
+		handshakeDone := make(chan struct{}, 1)
 
 		// Send handshake
 		if err := sendHandshake(conn); err != nil {
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// what a purge removes, one of user or since is set
+type purgeFilter struct {
+	user  string
+	since time.Time
+}
+
+// whether a message sent by user at timestamp (RFC 3339) should go
+func (f purgeFilter) matches(user string, timestamp string) bool {
+	if f.user != "" {
+		return user == f.user
+	}
+	ts, err := time.Parse(time.RFC3339, timestamp)
+	return err == nil && !ts.Before(f.since)
+}
+
+func (f purgeFilter) String() string {
+	if f.user != "" {
+		return "messages from " + f.user
+	}
+	return "messages since " + f.since.Local().Format("2006-01-02 15:04")
+}
+
+// how much a purge removed from each place
+type purgeResult struct {
+	ids     []string // IDs of the history messages removed
+	logged  int      // chat log records removed
+	mailbox int      // undelivered DMs and mentions removed
+}
+
+// "2h", "3d", YYYY-MM-DD or RFC 3339, a day or duration means that long ago
+func parsePurgeTime(s string) (time.Time, error) {
+	if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && strings.HasSuffix(s, "d") && days > 0 {
+		return time.Now().Add(-time.Duration(days) * 24 * time.Hour), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	t, err := parseSearchDate(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use something like 2h, 3d, 2024-05-01 or 2024-05-01T14:00:00Z", s)
+	}
+	return t, nil
+}
+
+// removes matching messages from the history store, the chat logs and the mailbox
+func purgeMessages(f purgeFilter) (purgeResult, error) {
+	var result purgeResult
+	result.ids = purgeHistory(f)
+	result.mailbox = purgeMailbox(f)
+	if chatLog != nil {
+		n, err := chatLog.purge(func(record chatLogRecord) bool {
+			return record.Event == "message" && f.matches(record.User, record.Time)
+		})
+		result.logged = n
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// drops matching messages from the history and rewrites the store so they're gone from disk too
+func purgeHistory(f purgeFilter) []string {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	var removed []string
+	removedIDs := make(map[string]bool)
+	kept := messageHistory[:0:0]
+	for _, msg := range messageHistory {
+		if f.matches(msg["user"], msg["timestamp"]) {
+			removed = append(removed, msg["id"])
+			removedIDs[msg["id"]] = true
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	// replies keep a quote of their parent, that has to go as well
+	for i, msg := range kept {
+		if removedIDs[msg["replyTo"]] {
+			updated := copyMessage(msg)
+			updated["replySnippet"] = purgedSnippet
+			kept[i] = updated
+		}
+	}
+	messageHistory = kept
+	reindexHistoryLocked()
+	if err := compactHistoryLocked(); err != nil {
+		fmt.Println("Error rewriting history:", err)
+	}
+	return removed
+}
+
+// shown instead of the quote of a purged message
+const purgedSnippet = "(removed)"
+
+// drops matching mail that hasn't been delivered yet
+func purgeMailbox(f purgeFilter) int {
+	mailboxMutex.Lock()
+	defer mailboxMutex.Unlock()
+
+	removed := 0
+	for user, waiting := range mailbox.Mail {
+		kept := waiting[:0:0]
+		for _, m := range waiting {
+			if f.matches(m.From, m.Timestamp) {
+				removed++
+				continue
+			}
+			kept = append(kept, m)
+		}
+		if len(kept) == 0 {
+			delete(mailbox.Mail, user)
+		} else {
+			mailbox.Mail[user] = kept
+		}
+	}
+	if removed > 0 {
+		saveMailboxLocked()
+	}
+	return removed
+}
+
+// tells clients what was purged so they can take it off their screen,
+// clients from before purges get a delete for each message, or failing that a clearChat
+func broadcastPurge(f purgeFilter, ids []string) {
+	notice := fmt.Sprintf("The server removed %s.", f)
+	purge := map[string]string{
+		"type":      "purge",
+		"user":      "server",
+		"message":   notice,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+	if f.user != "" {
+		purge["purgeUser"] = f.user
+	} else {
+		purge["since"] = f.since.UTC().Format(time.RFC3339)
+	}
+
+	clients.Range(func(key, value interface{}) bool {
+		client := value.(*ClientInfo)
+		if !client.isApproved {
+			return true
+		}
+		switch {
+		case client.Capabilities["purge"]:
+			sendToClient(client.Conn, purge)
+		case client.Capabilities["edit"]:
+			for _, id := range ids {
+				sendToClient(client.Conn, map[string]string{
+					"type":      "delete",
+					"user":      "server",
+					"id":        id,
+					"deletedBy": "server",
+				})
+			}
+		default:
+			sendToClient(client.Conn, map[string]string{
+				"type":    "clearChat",
+				"user":    "server",
+				"message": notice,
+			})
+		}
+		return true
+	})
+}
+
+// rewrites the chat log and every rotated segment without the records drop matches,
+// returns how many records were removed
+func (l *chatLogger) purge(drop func(chatLogRecord) bool) (int, error) {
+	l.housekeeping.Lock()
+	defer l.housekeeping.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	total := 0
+	if err := l.file.Close(); err != nil {
+		fmt.Println("Error closing chat log:", err)
+	}
+	day := l.day
+	n, err := rewriteLogFile(l.path, drop)
+	total += n
+	if openErr := l.openLocked(); openErr != nil {
+		return total, openErr
+	}
+	l.day = day // reopening shouldn't put off rotating a log from yesterday
+	if err != nil {
+		return total, err
+	}
+
+	for _, segment := range append(l.segments(false), l.segments(true)...) {
+		n, err := rewriteLogFile(segment, drop)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// copies a log file without the records drop matches, leaving it untouched if there are none
+func rewriteLogFile(path string, drop func(chatLogRecord) bool) (int, error) {
+	in, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	compressed := strings.HasSuffix(path, ".gz")
+	var reader io.Reader = in
+	if compressed {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	tmpPath := path + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpPath) // does nothing once it has replaced the original
+	var writer io.Writer = out
+	var gzOut *gzip.Writer
+	if compressed {
+		gzOut = gzip.NewWriter(out)
+		writer = gzOut
+	}
+	buffered := bufio.NewWriter(writer)
+
+	removed := 0
+	lines := bufio.NewReader(reader)
+	for {
+		line, readErr := lines.ReadBytes('\n')
+		var record chatLogRecord
+		if len(line) > 0 && (json.Unmarshal(line, &record) != nil || !drop(record)) {
+			buffered.Write(line) // anything we can't read is kept as it was
+		} else if len(line) > 0 {
+			removed++
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			out.Close()
+			return 0, fmt.Errorf("%s: %w", path, readErr)
+		}
+	}
+
+	err = buffered.Flush()
+	if err == nil && gzOut != nil {
+		err = gzOut.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, err
+	}
+	// retention goes by modification time, a purge shouldn't make old segments look new
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+	return removed, nil
+}
+
+// applies the retention settings to the history, the chat logs and the mailbox,
+// returns whether the history or mailbox lost anything
+func applyRetention() (history bool, mail int) {
+	history = enforceHistoryRetention()
+	mail = expireMail()
+	if chatLog != nil {
+		chatLog.compressAndPrune()
+	}
+	return history, mail
+}
+
+// applies retention every so often, so quiet servers still forget old messages
+func enforceRetention() {
+	for range time.Tick(time.Hour) {
+		applyRetention()
+	}
+}
+
+// days as it reads in //retention, where 0 means forever
+func describeDays(days int) string {
+	if days == 0 {
+		return "forever"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// prints the retention settings for //retention
+func printRetentionPolicy() {
+	maxMessages := "no limit"
+	if n := configInt("historyMaxMessages", defaultHistoryMaxMessages); n > 0 {
+		maxMessages = fmt.Sprintf("the newest %d messages", n)
+	}
+	fmt.Printf("History: %s, kept %s\n", maxMessages, describeDays(configInt("historyMaxAgeDays", defaultHistoryMaxAgeDays)))
+	if chatLog != nil {
+		fmt.Printf("Chat logs: rotated segments kept %s\n", describeDays(configInt("logRetentionDays", defaultLogRetentionDays)))
+	} else {
+		fmt.Println("Chat logs: off")
+	}
+	fmt.Printf("Mailbox: up to %d per user, kept %s\n", configInt("mailboxSize", defaultMailboxSize), describeDays(configInt("mailboxMaxAgeDays", defaultMailboxMaxAgeDays)))
+}
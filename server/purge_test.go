@@ -0,0 +1,177 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePurgeTime(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		value   string
+		want    time.Time // for relative times, roughly
+		wantErr bool
+	}{
+		{"2h", now.Add(-2 * time.Hour), false},
+		{"90m", now.Add(-90 * time.Minute), false},
+		{"3d", now.Add(-3 * 24 * time.Hour), false},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2024-05-01T14:00:00Z", time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC), false},
+		{"0d", time.Time{}, true},
+		{"-2h", time.Time{}, true},
+		{"d", time.Time{}, true},
+		{"3 days", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+	for _, c := range cases {
+		got, err := parsePurgeTime(c.value)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parsePurgeTime(%q) error = %v, want error %v", c.value, err, c.wantErr)
+			continue
+		}
+		if diff := got.Sub(c.want); diff < -time.Minute || diff > time.Minute {
+			t.Errorf("parsePurgeTime(%q) = %v, want about %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestPurgeFilterMatches(t *testing.T) {
+	byUser := purgeFilter{user: "mallory"}
+	since := purgeFilter{since: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+	cases := []struct {
+		filter    purgeFilter
+		user      string
+		timestamp string
+		want      bool
+	}{
+		{byUser, "mallory", "2024-01-01T00:00:00Z", true},
+		{byUser, "alice", "2024-01-01T00:00:00Z", false},
+		{byUser, "Mallory", "", false},
+		{since, "alice", "2024-05-01T00:00:00Z", true},
+		{since, "alice", "2024-06-01T00:00:00Z", true},
+		{since, "alice", "2024-04-30T23:59:59Z", false},
+		{since, "alice", "not a time", false},
+	}
+	for _, c := range cases {
+		if got := c.filter.matches(c.user, c.timestamp); got != c.want {
+			t.Errorf("%v matches(%q, %q) = %v, want %v", c.filter, c.user, c.timestamp, got, c.want)
+		}
+	}
+}
+
+const purgeTestLog = `{"ts":"2024-05-01T10:00:00Z","event":"join","room":"main","user":"mallory"}
+{"ts":"2024-05-01T10:00:01Z","event":"message","room":"main","id":"1","user":"mallory","message":"spam"}
+{"ts":"2024-05-01T10:00:02Z","event":"message","room":"main","id":"2","user":"alice","message":"hi"}
+half a record from a crash
+{"ts":"2024-05-01T10:00:03Z","event":"message","room":"main","id":"3","user":"mallory","message":"more spam"}
+`
+
+func dropMallory(r chatLogRecord) bool {
+	return r.Event == "message" && r.User == "mallory"
+}
+
+func TestRewriteLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.jsonl")
+	if err := os.WriteFile(path, []byte(purgeTestLog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := rewriteLogFile(path, dropMallory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d records, want 2", removed)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"ts":"2024-05-01T10:00:00Z","event":"join","room":"main","user":"mallory"}
+{"ts":"2024-05-01T10:00:02Z","event":"message","room":"main","id":"2","user":"alice","message":"hi"}
+half a record from a crash
+`
+	if string(data) != want {
+		t.Errorf("log after purge:\n%s\nwant:\n%s", data, want)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("modification time changed to %v", info.ModTime())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("permissions changed to %v", info.Mode().Perm())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+}
+
+func TestRewriteLogFileNothingToDrop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.jsonl")
+	if err := os.WriteFile(path, []byte(purgeTestLog), 0600); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := rewriteLogFile(path, func(chatLogRecord) bool { return false })
+	if err != nil || removed != 0 {
+		t.Errorf("rewriteLogFile = %d, %v, want 0, nil", removed, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != purgeTestLog {
+		t.Errorf("log changed:\n%s", data)
+	}
+
+	// and a log that doesn't exist (yet) is fine too
+	if removed, err := rewriteLogFile(filepath.Join(t.TempDir(), "missing.jsonl"), dropMallory); err != nil || removed != 0 {
+		t.Errorf("missing log: %d, %v", removed, err)
+	}
+}
+
+func TestRewriteLogFileCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat-2024-05-01.jsonl.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte(purgeTestLog))
+	gz.Close()
+	file.Close()
+
+	removed, err := rewriteLogFile(path, dropMallory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d records, want 2", removed)
+	}
+
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "spam") || !strings.Contains(string(data), `"user":"alice"`) {
+		t.Errorf("compressed log after purge:\n%s", data)
+	}
+}
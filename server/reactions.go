@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BananaJeans/tchat/internal/sanitize"
+)
+
+const (
+	maxReactionsPerMessage = 20 // distinct emoji on a single message
+	maxReactionRunes       = 8  // long enough for emoji with skin tones and joiners
+)
+
+// shortcodes clients can use instead of typing the emoji
+var reactionShortcodes = map[string]string{
+	"thumbsup":   "👍",
+	"+1":         "👍",
+	"thumbsdown": "👎",
+	"-1":         "👎",
+	"heart":      "❤️",
+	"joy":        "😂",
+	"laughing":   "😆",
+	"smile":      "😄",
+	"sob":        "😭",
+	"cry":        "😢",
+	"tada":       "🎉",
+	"fire":       "🔥",
+	"eyes":       "👀",
+	"thinking":   "🤔",
+	"wave":       "👋",
+	"clap":       "👏",
+	"rocket":     "🚀",
+	"ok":         "👌",
+	"pray":       "🙏",
+	"100":        "💯",
+	"check":      "✅",
+	"x":          "❌",
+	"skull":      "💀",
+}
+
+// unicode blocks emoji come from, reactions can only be made of these (plus joiners)
+var emojiRanges = [][2]rune{
+	{0x00a9, 0x00a9},   // ©
+	{0x00ae, 0x00ae},   // ®
+	{0x203c, 0x2049},   // ‼ ⁉
+	{0x2122, 0x2139},   // ™ ℹ
+	{0x2194, 0x21aa},   // arrows
+	{0x231a, 0x23ff},   // misc technical, ⌚ ⏰ ⏩
+	{0x24c2, 0x24c2},   // Ⓜ
+	{0x25aa, 0x25fe},   // geometric shapes
+	{0x2600, 0x27bf},   // misc symbols and dingbats
+	{0x2934, 0x2935},   // ⤴ ⤵
+	{0x2b05, 0x2b55},   // ⬅ ⬛ ⭐ ⭕
+	{0x3030, 0x3030},   // 〰
+	{0x303d, 0x303d},   // 〽
+	{0x3297, 0x3299},   // ㊗ ㊙
+	{0x1f000, 0x1faff}, // everything from mahjong tiles to symbols and pictographs extended-a, flags and skin tones included
+	{0xe0020, 0xe007f}, // tags, for subdivision flags like scotland's
+}
+
+const (
+	zeroWidthJoiner = 0x200d // joins emoji into one, like 👩‍💻
+	emojiVariation  = 0xfe0f // asks for the emoji look of a symbol, like ❤️
+)
+
+func isEmojiRune(r rune) bool {
+	for _, rng := range emojiRanges {
+		if r >= rng[0] && r <= rng[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// turns a shortcode like ":tada:" into its emoji, and rejects anything that isn't an emoji
+func normalizeReaction(reaction string) (string, error) {
+	reaction = strings.TrimSpace(reaction)
+	if emoji, ok := reactionShortcodes[strings.ToLower(strings.Trim(reaction, ":"))]; ok {
+		return emoji, nil
+	}
+
+	runes := []rune(reaction)
+	if len(runes) == 0 || len(runes) > maxReactionRunes || !isEmojiRune(runes[0]) {
+		return "", fmt.Errorf("unknown reaction %q", reaction)
+	}
+	// letters or symbols would just be a tiny message, only allow emoji
+	for _, r := range runes {
+		if !isEmojiRune(r) && r != zeroWidthJoiner && r != emojiVariation {
+			return "", fmt.Errorf("unknown reaction %q", reaction)
+		}
+	}
+	// nothing above should need it, but the reaction ends up on everyone's terminal
+	if sanitize.Line(reaction) != reaction {
+		return "", fmt.Errorf("unknown reaction %q", reaction)
+	}
+	return reaction, nil
+}
+
+// decodes the "reactions" field of a stored message, key: emoji, value: users who reacted
+func decodeReactions(field string) map[string][]string {
+	reactions := make(map[string][]string)
+	if field != "" {
+		json.Unmarshal([]byte(field), &reactions)
+	}
+	return reactions
+}
+
+// adds user's reaction to a stored message, or removes it if they already reacted with it
+func toggleReaction(id string, user string, emoji string) (string, error) {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	msg := findMessageLocked(id)
+	if msg == nil {
+		return "", fmt.Errorf("message #%s not found", id)
+	}
+
+	reactions := decodeReactions(msg["reactions"])
+	users := reactions[emoji]
+	removed := false
+	for i, u := range users {
+		if u == user {
+			users = append(users[:i], users[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	if !removed {
+		if _, exists := reactions[emoji]; !exists && len(reactions) >= maxReactionsPerMessage {
+			return "", errors.New("that message has too many different reactions")
+		}
+		users = append(users, user)
+	}
+	if len(users) == 0 {
+		delete(reactions, emoji)
+	} else {
+		reactions[emoji] = users
+	}
+
+	field := ""
+	if len(reactions) > 0 {
+		encoded, err := json.Marshal(reactions)
+		if err != nil {
+			return "", err
+		}
+		field = string(encoded)
+	}
+
+	updated := copyMessage(msg)
+	if field == "" {
+		delete(updated, "reactions")
+	} else {
+		updated["reactions"] = field
+	}
+	replaceMessageLocked(updated)
+	return field, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestNormalizeReaction(t *testing.T) {
+	cases := []struct {
+		name     string
+		reaction string
+		want     string // empty means it should be rejected
+	}{
+		{"shortcode", ":tada:", "🎉"},
+		{"shortcode without colons", "thumbsup", "👍"},
+		{"shortcode any case", ":FIRE:", "🔥"},
+		{"plain emoji", "🚀", "🚀"},
+		{"trimmed", "  👀 ", "👀"},
+		{"variation selector", "❤️", "❤️"},
+		{"skin tone", "👍🏽", "👍🏽"},
+		{"zwj sequence", "👩‍💻", "👩‍💻"},
+		{"flag", "🇳🇱", "🇳🇱"},
+		{"empty", "", ""},
+		{"ascii", "lol", ""},
+		{"unknown shortcode", ":nope:", ""},
+		{"letters", "héllo", ""},
+		{"cjk", "好", ""},
+		{"emoji then letters", "🔥ok", ""},
+		{"starts with a joiner", "‍🔥", ""},
+		{"escape sequence", "🔥\u001b[2J", ""},
+		{"bidi override", "🔥‮", ""},
+		{"too long", "🔥🔥🔥🔥🔥🔥🔥🔥🔥", ""},
+	}
+	for _, c := range cases {
+		got, err := normalizeReaction(c.reaction)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%s: normalizeReaction(%q) = %q, want an error", c.name, c.reaction, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%s: normalizeReaction(%q) = %q, %v, want %q", c.name, c.reaction, got, err, c.want)
+		}
+	}
+}
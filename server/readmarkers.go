@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// the last message ID a user got in a room before they left, 0 if we don't know or they
+// haven't proved the name is theirs
+func lastReadID(client *ClientInfo, room string) int64 {
+	if !client.isVerified {
+		return 0
+	}
+	mailboxMutex.Lock()
+	defer mailboxMutex.Unlock()
+	return mailbox.LastRead[client.Username][room]
+}
+
+// remembers the last message ID a user got, called when they leave
+func saveReadMarker(client *ClientInfo, room string, id int64) {
+	if id == 0 || !client.isVerified {
+		return
+	}
+	mailboxMutex.Lock()
+	defer mailboxMutex.Unlock()
+	if mailbox.LastRead[client.Username] == nil {
+		mailbox.LastRead[client.Username] = make(map[string]int64)
+	}
+	mailbox.LastRead[client.Username][room] = id
+	saveMailboxLocked()
+}
+
+// notes that a client got a message, so we know where they left off
+func markSeen(client *ClientInfo, message map[string]string) {
+	if id, err := strconv.ParseInt(message["id"], 10, 64); err == nil && id > client.lastSeenID.Load() {
+		client.lastSeenID.Store(id)
+	}
+}
+
+// messages after afterID, up to the newest limit of them, and how many of those are unread,
+// which leaves out the user's own messages and server notices like joins
+func unreadMessages(afterID int64, username string, limit int) ([]map[string]string, int) {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	var since []map[string]string
+	unread := 0
+	for _, msg := range messageHistory {
+		id, err := strconv.ParseInt(msg["id"], 10, 64)
+		if err != nil || id <= afterID {
+			continue
+		}
+		since = append(since, msg)
+		if msg["user"] != username && msg["user"] != "server" {
+			unread++
+		}
+	}
+	if len(since) > limit {
+		since = since[len(since)-limit:]
+	}
+	return append([]map[string]string(nil), since...), unread
+}
+
+// replays the messages a client missed since they last left (or just the recent ones if it's their
+// first visit), then tells them how many are unread so they can mark where they left off,
+// returns the oldest message ID replayed, 0 if none were
+func sendMessageHistory(client *ClientInfo) int64 {
+	history := recentMessages(configInt("historySendCount", defaultHistorySendCount))
+	lastRead := lastReadID(client, defaultRoom)
+	unread := 0
+	if lastRead != 0 {
+		var missed []map[string]string
+		missed, unread = unreadMessages(lastRead, client.Username, maxHistoryPageSize)
+		if len(missed) > len(history) {
+			history = missed
+		}
+	}
+
+	var oldest int64
+	for i, msg := range history {
+		if sendToClient(client.Conn, msg) != nil {
+			return oldest
+		}
+		if i == 0 {
+			oldest, _ = strconv.ParseInt(msg["id"], 10, 64)
+		}
+		markSeen(client, msg)
+		// small delay to avoid messing up the client
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if unread == 0 {
+		return oldest
+	}
+	if client.Capabilities["unread"] {
+		sendToClient(client.Conn, map[string]string{
+			"type":  "unread",
+			"since": strconv.FormatInt(lastRead, 10),
+			"count": strconv.Itoa(unread),
+		})
+	} else {
+		serverDmUser(fmt.Sprintf("You have %d unread message(s) since you were last here.", unread), client.Username)
+	}
+	return oldest
+}
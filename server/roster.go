@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+)
+
+// usernames of everyone who's finished the handshake, sorted
+func onlineUsers() []string {
+	var users []string
+	clients.Range(func(key, value interface{}) bool {
+		client := value.(*ClientInfo)
+		if client.isApproved && client.Username != "" {
+			users = append(users, client.Username)
+		}
+		return true
+	})
+	sort.Strings(users)
+	return users
+}
+
+// tells clients who's online, sent whenever someone joins or leaves
+func broadcastRoster() {
+	users := onlineUsers()
+	if users == nil {
+		users = []string{}
+	}
+	// encoded like reactions, usernames can have commas in them
+	field, err := json.Marshal(users)
+	if err != nil {
+		log.Println("Error marshaling roster:", err)
+		return
+	}
+	broadcastToCapable(map[string]string{
+		"type":  "roster",
+		"user":  "server",
+		"users": string(field),
+	}, "roster", nil)
+}
@@ -0,0 +1,17 @@
+package main
+
+import "github.com/BananaJeans/tchat/internal/sanitize"
+
+// cleans every field a client sent, only the message text can have newlines.
+// passwords and identity tokens are compared rather than shown, so they're left as they are
+func sanitizeClientMessage(jsonMsg map[string]string) {
+	for key, value := range jsonMsg {
+		switch key {
+		case "serverPassword", "moderatorPassword", "identityToken":
+		case "message":
+			jsonMsg[key] = sanitize.Text(value)
+		default:
+			jsonMsg[key] = sanitize.Line(value)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestSanitizeClientMessage(t *testing.T) {
+	msg := map[string]string{
+		"type":           "handshake",
+		"user":           "mal\u001b[31mlory",
+		"message":        "OK\u001b[2J",
+		"serverPassword": "p\u0007ss",
+	}
+	sanitizeClientMessage(msg)
+	if msg["user"] != "mallory" || msg["message"] != "OK" {
+		t.Errorf("got user %q and message %q", msg["user"], msg["message"])
+	}
+	if msg["serverPassword"] != "p\u0007ss" {
+		t.Errorf("password was changed to %q", msg["serverPassword"])
+	}
+}
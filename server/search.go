@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxSearchResults     = 50  // newest matches sent back for one search
+	maxSearchQueryLength = 200 // runes, longer queries are refused
+	searchDateLayout     = "2006-01-02"
+)
+
+// one word or quoted phrase of a search, matched case-insensitively anywhere in the message
+type searchTerm struct {
+	text   string
+	negate bool // "-word" or "NOT word", the message must not contain it
+}
+
+// a parsed //search query
+type searchQuery struct {
+	clauses [][]searchTerm // every clause has to match, a clause matches if any of its terms does (OR)
+	from    []string       // only messages from one of these users, empty for anyone
+	before  time.Time      // only messages sent before this, zero for no limit
+	after   time.Time      // only messages sent on or after this, zero for no limit
+}
+
+// one piece of a query, quoted pieces are never treated as keywords or filters
+type searchToken struct {
+	text   string
+	quoted bool
+}
+
+// splits a query on whitespace, keeping "quoted phrases" together
+func tokenizeSearch(query string) ([]searchToken, error) {
+	var tokens []searchToken
+	var current strings.Builder
+	inQuotes := false
+	hasToken := false
+	prefix := "" // a "-" or "from:" right before a quote belongs to the phrase
+
+	flush := func(quoted bool) {
+		if hasToken {
+			tokens = append(tokens, searchToken{text: prefix + current.String(), quoted: quoted})
+		}
+		current.Reset()
+		hasToken = false
+		prefix = ""
+	}
+
+	for _, r := range query {
+		switch {
+		case r == '"' && inQuotes:
+			inQuotes = false
+			hasToken = true // "" is still a (useless) token
+			flush(true)
+		case r == '"':
+			prefix = current.String()
+			current.Reset()
+			inQuotes = true
+		case unicode.IsSpace(r) && !inQuotes:
+			flush(false)
+		default:
+			current.WriteRune(r)
+			hasToken = true
+		}
+	}
+	if inQuotes {
+		return nil, errors.New("unterminated quote")
+	}
+	flush(false)
+	return tokens, nil
+}
+
+// parses a date filter, either a day (in UTC) or a full RFC 3339 timestamp
+func parseSearchDate(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(searchDateLayout, value, time.UTC); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", value)
+}
+
+// parses a query like `deploy OR release -staging from:alice before:2024-06-01`
+func parseSearchQuery(query string) (*searchQuery, error) {
+	if len([]rune(query)) > maxSearchQueryLength {
+		return nil, fmt.Errorf("query is too long (max %d characters)", maxSearchQueryLength)
+	}
+	tokens, err := tokenizeSearch(query)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &searchQuery{}
+	joinNext := false   // last token was OR
+	negateNext := false // last token was NOT
+	for _, token := range tokens {
+		text := token.text
+		if !token.quoted {
+			switch {
+			case text == "OR":
+				if len(q.clauses) == 0 || joinNext {
+					return nil, errors.New("OR needs a term on both sides")
+				}
+				joinNext = true
+				continue
+			case text == "NOT":
+				negateNext = true
+				continue
+			case strings.HasPrefix(text, "from:"):
+				user := strings.TrimPrefix(strings.TrimPrefix(text, "from:"), "@")
+				if user == "" {
+					return nil, errors.New("from: needs a username")
+				}
+				q.from = append(q.from, user)
+				continue
+			case strings.HasPrefix(text, "before:"):
+				if q.before, err = parseSearchDate(strings.TrimPrefix(text, "before:")); err != nil {
+					return nil, err
+				}
+				continue
+			case strings.HasPrefix(text, "after:"):
+				if q.after, err = parseSearchDate(strings.TrimPrefix(text, "after:")); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		term := searchTerm{negate: negateNext}
+		if strings.HasPrefix(text, "-") && len(text) > 1 {
+			term.negate = true
+			text = text[1:]
+		}
+		term.text = strings.ToLower(text)
+		negateNext = false
+		if term.text == "" {
+			continue
+		}
+
+		if joinNext {
+			last := len(q.clauses) - 1
+			q.clauses[last] = append(q.clauses[last], term)
+			joinNext = false
+		} else {
+			q.clauses = append(q.clauses, []searchTerm{term})
+		}
+	}
+
+	if joinNext {
+		return nil, errors.New("OR needs a term on both sides")
+	}
+	if len(q.clauses) == 0 && len(q.from) == 0 && q.before.IsZero() && q.after.IsZero() {
+		return nil, errors.New("nothing to search for")
+	}
+	return q, nil
+}
+
+// whether a stored message matches the query
+func (q *searchQuery) matches(message map[string]string) bool {
+	if len(q.from) > 0 {
+		found := false
+		for _, user := range q.from {
+			if strings.EqualFold(message["user"], user) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !q.before.IsZero() || !q.after.IsZero() {
+		ts, err := time.Parse(time.RFC3339, message["timestamp"])
+		if err != nil {
+			return false
+		}
+		if !q.before.IsZero() && !ts.Before(q.before) {
+			return false
+		}
+		if !q.after.IsZero() && ts.Before(q.after) {
+			return false
+		}
+	}
+
+	text := strings.ToLower(message["message"])
+	for _, clause := range q.clauses {
+		matched := false
+		for _, term := range clause {
+			if strings.Contains(text, term.text) != term.negate {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// the newest stored messages matching the query (oldest first), and whether there were more than limit
+func searchMessages(q *searchQuery, limit int) ([]map[string]string, bool) {
+	messageHistoryMutex.Lock()
+	defer messageHistoryMutex.Unlock()
+
+	var results []map[string]string
+	for i := len(messageHistory) - 1; i >= 0; i-- {
+		if !q.matches(messageHistory[i]) {
+			continue
+		}
+		if len(results) == limit {
+			reverseMessages(results)
+			return results, true
+		}
+		results = append(results, copyMessage(messageHistory[i]))
+	}
+	reverseMessages(results)
+	return results, false
+}
+
+func reverseMessages(messages []map[string]string) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchDate(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2024-06-01", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2024-06-01T12:30:00Z", time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC), false},
+		{"2024-06-01T12:30:00+02:00", time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC), false},
+		{"2024-13-01", time.Time{}, true},
+		{"01/06/2024", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+		{"", time.Time{}, true},
+	}
+	for _, c := range cases {
+		got, err := parseSearchDate(c.value)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseSearchDate(%q) error = %v, want error %v", c.value, err, c.wantErr)
+			continue
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("parseSearchDate(%q) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC) }
+	cases := []struct {
+		query   string
+		want    *searchQuery // nil means it should be refused
+		wantErr bool
+	}{
+		{"Deploy", &searchQuery{clauses: [][]searchTerm{{{text: "deploy"}}}}, false},
+		{"deploy staging", &searchQuery{clauses: [][]searchTerm{{{text: "deploy"}}, {{text: "staging"}}}}, false},
+		{"deploy OR release", &searchQuery{clauses: [][]searchTerm{{{text: "deploy"}, {text: "release"}}}}, false},
+		{"deploy -staging", &searchQuery{clauses: [][]searchTerm{{{text: "deploy"}}, {{text: "staging", negate: true}}}}, false},
+		{"deploy NOT staging", &searchQuery{clauses: [][]searchTerm{{{text: "deploy"}}, {{text: "staging", negate: true}}}}, false},
+		{`"release notes" v2`, &searchQuery{clauses: [][]searchTerm{{{text: "release notes"}}, {{text: "v2"}}}}, false},
+		{`-"release notes"`, &searchQuery{clauses: [][]searchTerm{{{text: "release notes", negate: true}}}}, false},
+		{`"OR" "from:bob"`, &searchQuery{clauses: [][]searchTerm{{{text: "or"}}, {{text: "from:bob"}}}}, false},
+		{"from:alice", &searchQuery{from: []string{"alice"}}, false},
+		{"from:@alice from:bob hi", &searchQuery{clauses: [][]searchTerm{{{text: "hi"}}}, from: []string{"alice", "bob"}}, false},
+		{"after:2024-06-01 before:2024-06-03", &searchQuery{after: day(1), before: day(3)}, false},
+		{"from:", nil, true},
+		{"before:soon", nil, true},
+		{"after:2024-06-01T25:00:00Z", nil, true},
+		{"OR deploy", nil, true},
+		{"deploy OR", nil, true},
+		{"deploy OR OR release", nil, true},
+		{`"unterminated`, nil, true},
+		{"", nil, true},
+		{"   ", nil, true},
+	}
+	for _, c := range cases {
+		got, err := parseSearchQuery(c.query)
+		if (err != nil) != c.wantErr {
+			t.Errorf("parseSearchQuery(%q) error = %v, want error %v", c.query, err, c.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", c.query, got, c.want)
+		}
+	}
+}
+
+func TestSearchQueryMatches(t *testing.T) {
+	message := map[string]string{
+		"user":      "Alice",
+		"message":   "Deploying the release notes to staging",
+		"timestamp": "2024-06-02T09:00:00Z",
+	}
+	cases := []struct {
+		query string
+		want  bool
+	}{
+		{"deploy", true},
+		{"deploy prod", false},
+		{"prod OR staging", true},
+		{"deploy -staging", false},
+		{`"release notes"`, true},
+		{`"notes release"`, false},
+		{"from:alice", true},
+		{"from:bob", false},
+		{"from:bob from:alice", true},
+		{"after:2024-06-02", true},
+		{"before:2024-06-02", false},
+		{"after:2024-06-01 before:2024-06-03", true},
+	}
+	for _, c := range cases {
+		q, err := parseSearchQuery(c.query)
+		if err != nil {
+			t.Fatalf("parseSearchQuery(%q): %v", c.query, err)
+		}
+		if got := q.matches(message); got != c.want {
+			t.Errorf("%q matches = %v, want %v", c.query, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// columns a rune takes up in a terminal: 0 for combining marks and other invisible ones,
+// 2 for East Asian wide characters and most emoji, 1 for everything else
+func runeWidth(r rune) int {
+	switch {
+	case r == 0x200d || (r >= 0xfe00 && r <= 0xfe0f): // zero width joiner, variation selectors
+		return 0
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) || unicode.IsControl(r):
+		return 0
+	case r >= 0x1f300 && r <= 0x1faff: // emoji and pictographs, some aren't marked wide but every terminal draws them that way
+		return 2
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// columns text takes up, ignoring ansi color codes
+func displayWidth(text string) int {
+	total := 0
+	inEscape := false
+	for _, r := range text {
+		switch {
+		case inEscape:
+			inEscape = r < 0x40 || r > 0x7e || r == '[' // a CSI ends with a byte in 0x40-0x7e
+		case r == 0x1b:
+			inEscape = true
+		default:
+			total += runeWidth(r)
+		}
+	}
+	return total
+}
+
+// splits text into lines of at most width columns, breaking between words where it can
+// and only splitting a word that's wider than a whole line, never in the middle of a character
+func wrapText(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		lines = append(lines, wrapParagraph(paragraph, width)...)
+	}
+	// keep the old behaviour of no lines at all for empty text
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	return lines
+}
+
+func wrapParagraph(text string, width int) []string {
+	var lines []string
+	var line strings.Builder
+	lineWidth := 0
+	flush := func() {
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+		line.Reset()
+		lineWidth = 0
+	}
+
+	for _, word := range splitWords(text) {
+		wordWidth := displayWidth(word)
+		isSpace := strings.TrimLeft(word, " ") == ""
+		switch {
+		case isSpace && lineWidth == 0 && len(lines) > 0:
+			// don't start a wrapped line with the spaces it was wrapped at
+		case lineWidth+wordWidth <= width:
+			line.WriteString(word)
+			lineWidth += wordWidth
+		case isSpace:
+			flush() // the break goes where the spaces were
+		case wordWidth <= width:
+			flush()
+			line.WriteString(word)
+			lineWidth = wordWidth
+		default:
+			// too long for any line, fill this one up and carry on with the rest
+			inEscape := false
+			for _, r := range word {
+				w := runeWidth(r)
+				switch {
+				case inEscape:
+					inEscape = r < 0x40 || r > 0x7e || r == '['
+					w = 0
+				case r == 0x1b:
+					inEscape = true
+				}
+				if lineWidth+w > width && lineWidth > 0 {
+					flush()
+				}
+				line.WriteRune(r)
+				lineWidth += w
+			}
+		}
+	}
+	if line.Len() > 0 || len(lines) == 0 {
+		flush()
+	}
+	return lines
+}
+
+// splits text into runs of spaces and runs of everything else
+func splitWords(text string) []string {
+	var words []string
+	start := 0
+	for i, r := range text {
+		if i > start && (r == ' ') != (text[start] == ' ') {
+			words = append(words, text[start:i])
+			start = i
+		}
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// shortens text to fit in width columns, marking the cut with an ellipsis
+func truncateToWidth(text string, width int) string {
+	if displayWidth(text) <= width {
+		return text
+	}
+	if width < 1 {
+		return ""
+	}
+	var b strings.Builder
+	used := 0
+	for _, r := range text {
+		w := runeWidth(r)
+		if used+w > width-1 {
+			break
+		}
+		b.WriteRune(r)
+		used += w
+	}
+	return b.String() + "…"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	cases := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"日本語", 6},
+		{"ｆｕｌｌ", 8},            // fullwidth latin
+		{"e\u0301te\u0301", 3}, // e plus a combining acute
+		{"🔥", 2},
+		{"👩‍💻", 4}, // two wide emoji, the joiner itself takes no room
+		{"❤️", 1},  // a narrow symbol, the variation selector takes no room
+		{"\033[31mred\033[0m", 3},
+		{"\033[1;38;5;202mbold\033[22m", 4},
+		{"a\u200bb", 2}, // zero width space
+	}
+	for _, c := range cases {
+		if got := displayWidth(c.text); got != c.want {
+			t.Errorf("displayWidth(%q) = %d, want %d", c.text, got, c.want)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	cases := []struct {
+		name  string
+		text  string
+		width int
+		want  []string
+	}{
+		{"empty", "", 10, nil},
+		{"fits", "hello world", 20, []string{"hello world"}},
+		{"breaks between words", "hello there world", 11, []string{"hello there", "world"}},
+		{"drops the spaces it breaks at", "aaa    bbb", 5, []string{"aaa", "bbb"}},
+		{"keeps spaces inside a line", "a  b", 10, []string{"a  b"}},
+		{"long word", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"long word after a short one", "hi abcdefgh", 5, []string{"hi ab", "cdefg", "h"}},
+		{"newlines", "one\ntwo three", 5, []string{"one", "two", "three"}},
+		{"blank line", "one\n\ntwo", 5, []string{"one", "", "two"}},
+		{"wide characters", "日本語のテキスト", 5, []string{"日本", "語の", "テキ", "スト"}},
+		{"wide character never split", "ab日本", 3, []string{"ab", "日", "本"}},
+		{"combining marks stay with their letter", "e\u0301e\u0301e\u0301", 2, []string{"e\u0301e\u0301", "e\u0301"}},
+		{"emoji", "🔥🔥🔥", 4, []string{"🔥🔥", "🔥"}},
+		{"color codes take no room", "\033[31mabc\033[0m def", 3, []string{"\033[31mabc\033[0m", "def"}},
+		{"zero width", "abc", 0, []string{"a", "b", "c"}},
+	}
+	for _, c := range cases {
+		if got := wrapText(c.text, c.width); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: wrapText(%q, %d) = %q, want %q", c.name, c.text, c.width, got, c.want)
+		}
+	}
+}
+
+func TestTruncateToWidth(t *testing.T) {
+	cases := []struct {
+		text  string
+		width int
+		want  string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello world", 6, "hello…"},
+		{"日本語", 6, "日本語"},
+		{"日本語", 5, "日本…"},
+		{"日本語", 4, "日…"},
+		{"e\u0301te\u0301s", 3, "e\u0301t…"},
+		{"🔥🔥", 3, "🔥…"},
+		{"hello", 1, "…"},
+		{"hello", 0, ""},
+	}
+	for _, c := range cases {
+		if got := truncateToWidth(c.text, c.width); got != c.want {
+			t.Errorf("truncateToWidth(%q, %d) = %q, want %q", c.text, c.width, got, c.want)
+		}
+	}
+}
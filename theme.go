@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const themesDir = "./themes" // next to tchatconfig.json, one <name>.json per theme
+
+// colors a theme sets, anything it leaves out follows themeColor
+type theme struct {
+	Banner    string `json:"banner"`    // the header, view titles and the new messages divider
+	Server    string `json:"server"`    // server messages
+	Mention   string `json:"mention"`   // @mentions in messages
+	Timestamp string `json:"timestamp"` // message times and day separators
+	DM        string `json:"dm"`        // the DM marker in front of direct messages
+}
+
+// the theme in use, empty for the default, guarded by screenMutex
+var activeTheme theme
+
+// reads themes/<name>.json, "default" (or no name) is the one built from themeColor
+func loadTheme(name string) (theme, error) {
+	if name == "" || name == "default" {
+		return theme{}, nil
+	}
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return theme{}, fmt.Errorf("theme %q isn't a theme name, just use the file name without .json", name)
+	}
+	data, err := os.ReadFile(filepath.Join(themesDir, name+".json"))
+	if os.IsNotExist(err) {
+		return theme{}, fmt.Errorf("no theme called %s, themes go in %s", name, themesDir)
+	} else if err != nil {
+		return theme{}, err
+	}
+	var t theme
+	if err := json.Unmarshal(data, &t); err != nil {
+		return theme{}, fmt.Errorf("theme %s: %v", name, err)
+	}
+	fields := map[string]string{"banner": t.Banner, "server": t.Server, "mention": t.Mention, "timestamp": t.Timestamp, "dm": t.DM}
+	for field, color := range fields {
+		if color != "" && !isValidColor(color) {
+			return theme{}, fmt.Errorf("theme %s: %s must be a color name, a 256 color number or #rrggbb", name, field)
+		}
+	}
+	return t, nil
+}
+
+// every theme there is, the default first
+func listThemes() []string {
+	names := []string{"default"}
+	files, _ := filepath.Glob(filepath.Join(themesDir, "*.json"))
+	sort.Strings(files)
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	return names
+}
+
+// the theme's color for something, or fallback if it doesn't set one
+func themeCode(color string, fallback string) string {
+	if color != "" {
+		return colorCode(color)
+	}
+	return fallback
+}
+
+func serverColorCode() string {
+	return themeCode(activeTheme.Server, themeColorCode())
+}
+
+func mentionColorCode() string {
+	return themeCode(activeTheme.Mention, themeColorCode())
+}
+
+func timestampColorCode() string {
+	return themeCode(activeTheme.Timestamp, "\033[2m") // dim
+}
+
+func dmColorCode() string {
+	return themeCode(activeTheme.DM, themeColorCode())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimestampFormat  = "[15:04]"
+	fallbackTimestampFormat = "15:04" // for views that need times even when timestampFormat hides them
+	daySeparatorFormat      = "Mon Jan 2 2006"
+)
+
+// the Go time layout messages are stamped with, empty to hide times
+func timestampFormat() string {
+	if format, ok := config["timestampFormat"].(string); ok {
+		return format
+	}
+	return defaultTimestampFormat
+}
+
+// the "--- Mon Jan 2 2006 ---" line shown when the date changes
+func daySeparator(day time.Time) string {
+	return timestampColorCode() + "--- " + day.Format(daySeparatorFormat) + " ---" + ansiColors["reset"]
+}
+
+// renders an entry with the local time it was sent in front of its first line, the rest indented to match
+func renderTimedEntry(entry *chatEntry, width int, layout string) []string {
+	if layout == "" {
+		return renderEntry(entry, width)
+	}
+	prefix := time.Now().Format(layout) + " "
+	prefixWidth := displayWidth(prefix)
+	if !entry.timestamp.IsZero() {
+		prefix = entry.timestamp.Local().Format(layout) + " "
+	} else {
+		prefix = strings.Repeat(" ", prefixWidth) // keep entries without a time lined up
+	}
+
+	lines := renderEntry(entry, width-prefixWidth)
+	indent := strings.Repeat(" ", prefixWidth)
+	for i := range lines {
+		if i == 0 {
+			lines[i] = timestampColorCode() + prefix + ansiColors["reset"] + lines[i]
+		} else {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return lines
+}
+
+// renders entries with their times and a separator whenever the day changes,
+// including before the first entry unless it's from today, and the "new messages"
+// divider above the first message after unreadAfter (0 for none)
+func renderTimedEntries(list []*chatEntry, width int, layout string, unreadAfter int64) []string {
+	var lines []string
+	dividerDrawn := false
+	lastDay := ""
+	today := time.Now().Format("2006-01-02")
+	for _, entry := range list {
+		if !entry.timestamp.IsZero() {
+			local := entry.timestamp.Local()
+			day := local.Format("2006-01-02")
+			if day != lastDay && (lastDay != "" || day != today) {
+				lines = append(lines, daySeparator(local))
+			}
+			lastDay = day
+		}
+		if !dividerDrawn && isFirstUnread(entry, unreadAfter) {
+			lines = append(lines, unreadDivider())
+			dividerDrawn = true
+		}
+		lines = append(lines, renderTimedEntry(entry, width, layout)...)
+	}
+	return lines
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const maxSessionMessages = 10000 // messages kept for //save, the oldest go first
+
+// raw message log for the server we're connected to, nil when localTranscripts is off
+var transcriptFile *os.File
+
+// the newest message already in the transcript, so the history the server replays on join isn't logged twice.
+// the time matters too, a server that keeps its history in memory starts its IDs again at 1 when it restarts
+var (
+	transcriptLastID   int64
+	transcriptLastTime time.Time
+)
+
+// every message shown this session, for //save, guarded by screenMutex
+var sessionMessages []*chatEntry
+
+// where tchat keeps its data, following each OS's convention
+func dataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "tchat"), nil
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "tchat"), nil
+		}
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Application Support", "tchat"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "tchat"), nil
+}
+
+func transcriptDir() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "transcripts"), nil
+}
+
+// one transcript per server, named after its address
+func transcriptPath(server string) (string, error) {
+	dir, err := transcriptDir()
+	if err != nil {
+		return "", err
+	}
+	name := regexp.MustCompile(`[^A-Za-z0-9.-]+`).ReplaceAllString(server, "_")
+	return filepath.Join(dir, name+".jsonl"), nil
+}
+
+// opens the transcript for a server for appending, picking up where the last session left off
+func openTranscript(server string) error {
+	path, err := transcriptPath(server)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// find the newest message we already have
+	if existing, err := os.Open(path); err == nil {
+		reader := bufio.NewReader(existing)
+		for {
+			line, err := reader.ReadBytes('\n')
+			var jsonMsg map[string]string
+			if json.Unmarshal(line, &jsonMsg) == nil && jsonMsg["type"] == "message" {
+				noteTranscribed(jsonMsg)
+			}
+			if err != nil {
+				break
+			}
+		}
+		existing.Close()
+	}
+
+	transcriptFile, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return err
+}
+
+// appends a message, DM, edit, delete, reaction or purge from the server to the transcript
+func logTranscript(jsonMsg map[string]string) {
+	if transcriptFile == nil {
+		return
+	}
+	if jsonMsg["type"] == "message" && !noteTranscribed(jsonMsg) {
+		return // already logged last time
+	}
+
+	record := make(map[string]string, len(jsonMsg)+1)
+	for k, v := range jsonMsg {
+		record[k] = v
+	}
+	record["receivedAt"] = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if _, err := transcriptFile.Write(append(data, '\n')); err != nil {
+		addServerMessage("Error writing transcript, turning it off: "+err.Error(), "bold_red")
+		transcriptFile.Close()
+		transcriptFile = nil
+	}
+}
+
+// moves the transcript's newest message along, returns false if the message is one we already have:
+// no newer than the newest by ID, and not sent after it either
+func noteTranscribed(jsonMsg map[string]string) bool {
+	id := parseMessageID(jsonMsg["id"])
+	if id == 0 {
+		return true
+	}
+	sent, err := time.Parse(time.RFC3339, jsonMsg["timestamp"])
+	restarted := err == nil && sent.After(transcriptLastTime) // a new ID sequence
+	if id <= transcriptLastID && !restarted {
+		return false
+	}
+	transcriptLastID = id
+	if err == nil {
+		transcriptLastTime = sent
+	}
+	return true
+}
+
+// keeps a shown message around for //save, caller must hold screenMutex
+func recordSessionMessage(entry *chatEntry) {
+	sessionMessages = append(sessionMessages, entry)
+	if len(sessionMessages) > maxSessionMessages {
+		sessionMessages = sessionMessages[len(sessionMessages)-maxSessionMessages:]
+	}
+}
+
+// builds an entry for a message from the server itself, shown like a notice
+func serverEntryFromMessage(jsonMsg map[string]string) *chatEntry {
+	entry := entryFromMessage(jsonMsg)
+	entry.notice = true
+	entry.color = serverColorCode()
+	return entry
+}
+
+// one line of a plain text transcript
+func formatTranscriptLine(entry *chatEntry) string {
+	when := "                " // same width as a time, for entries without one
+	if !entry.timestamp.IsZero() {
+		when = entry.timestamp.Local().Format("2006-01-02 15:04")
+	}
+	switch {
+	case entry.notice:
+		return fmt.Sprintf("%s * %s", when, entry.text)
+	case entry.deleted:
+		return fmt.Sprintf("%s #%d [%s] (message deleted)", when, entry.id, entry.user)
+	}
+	line := fmt.Sprintf("%s #%d [%s] ", when, entry.id, entry.user)
+	if entry.dm {
+		line = fmt.Sprintf("%s DM [%s] ", when, entry.user)
+		if entry.dmTo != "" {
+			line = fmt.Sprintf("%s DM to %s [%s] ", when, entry.dmTo, entry.user)
+		}
+	}
+	if entry.replyTo != 0 {
+		line += fmt.Sprintf("(reply to #%d) ", entry.replyTo)
+	}
+	line += entry.text
+	if entry.edited {
+		line += " (edited)"
+	}
+	if len(entry.reactions) > 0 {
+		line += "  [" + stripAnsiCodes(reactionSummary(entry.reactions)) + "]"
+	}
+	return line
+}
+
+// writes every message of this session to a new text file
+func saveSession(path string) (int, error) {
+	screenMutex.Lock()
+	lines := make([]string, 0, len(sessionMessages))
+	for _, entry := range sessionMessages {
+		lines = append(lines, formatTranscriptLine(entry))
+	}
+	screenMutex.Unlock()
+
+	// don't clobber anything by accident
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "tchat session on %s (%s), saved %s\n\n", serverName, config["server"], time.Now().Format("2006-01-02 15:04"))
+	for _, line := range lines {
+		fmt.Fprintln(writer, line)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return 0, err
+	}
+	return len(lines), file.Close()
+}
+
+// `tchat log [server]`, lists saved transcripts or pages through one, returns the exit code
+func runLogViewer(args []string) int {
+	dir, err := transcriptDir()
+	if err != nil {
+		fmt.Println("Error finding transcripts:", err)
+		return 1
+	}
+
+	if len(args) == 0 {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+		if len(files) == 0 {
+			fmt.Println("No transcripts yet, set localTranscripts to true in tchatconfig.json to start keeping them.")
+			return 0
+		}
+		sort.Strings(files)
+		fmt.Println("Transcripts in", dir+":")
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			fmt.Printf("  %-30s %8d KB  last message %s\n", strings.TrimSuffix(filepath.Base(file), ".jsonl"), (info.Size()+1023)/1024, info.ModTime().Format("2006-01-02 15:04"))
+		}
+		fmt.Println("Run tchat log <name> to read one.")
+		return 0
+	}
+
+	// a name from the list, a server address, or a path
+	path := args[0]
+	if _, err := os.Stat(path); err != nil {
+		path = filepath.Join(dir, strings.TrimSuffix(args[0], ".jsonl")+".jsonl")
+		if _, err := os.Stat(path); err != nil {
+			if path, err = transcriptPath(args[0]); err != nil {
+				fmt.Println("Error finding transcript:", err)
+				return 1
+			}
+		}
+	}
+	transcript, err := readTranscript(path)
+	if err != nil {
+		fmt.Println("Error reading transcript:", err)
+		return 1
+	}
+
+	width := 80
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		width = w
+	}
+	layout := timestampFormat()
+	if layout == "" {
+		layout = fallbackTimestampFormat // a log without times isn't much use
+	}
+	lines := renderTimedEntries(transcript, width, layout, 0)
+	if len(lines) == 0 {
+		fmt.Println("That transcript is empty.")
+		return 0
+	}
+	showInPager(strings.Join(lines, "\n") + "\n")
+	return 0
+}
+
+// replays a transcript into entries, applying edits, deletes, reactions and purges
+func readTranscript(path string) ([]*chatEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var transcript []*chatEntry
+	byID := make(map[int64]*chatEntry)
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		var jsonMsg map[string]string
+		if json.Unmarshal(line, &jsonMsg) == nil {
+			sanitizeMessage(jsonMsg) // older transcripts were saved before we cleaned messages
+			id := parseMessageID(jsonMsg["id"])
+			switch jsonMsg["type"] {
+			case "message":
+				var entry *chatEntry
+				if jsonMsg["user"] == "server" {
+					entry = serverEntryFromMessage(jsonMsg)
+				} else {
+					entry = entryFromMessage(jsonMsg)
+				}
+				transcript = append(transcript, entry)
+				if id != 0 {
+					byID[id] = entry
+				}
+			case "dm":
+				transcript = append(transcript, entryFromDirectMessage(jsonMsg))
+			case "edit":
+				if entry := byID[id]; entry != nil {
+					entry.text = jsonMsg["message"]
+					entry.edited = true
+				}
+			case "delete":
+				if entry := byID[id]; entry != nil {
+					entry.text = ""
+					entry.deleted = true
+				}
+			case "reaction":
+				if entry := byID[id]; entry != nil {
+					entry.reactions = parseReactions(jsonMsg["reactions"])
+				}
+			case "purge":
+				if purge := purgeMatcher(jsonMsg); purge != nil {
+					transcript = purgeEntries(transcript, purge)
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return transcript, nil
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+}
+
+// shows text through $PAGER (or less), or just prints it if there's no pager or no terminal
+func showInPager(text string) {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Print(text)
+		return
+	}
+	var cmd *exec.Cmd
+	if pager := os.Getenv("PAGER"); pager != "" {
+		fields := strings.Fields(pager)
+		cmd = exec.Command(fields[0], fields[1:]...)
+	} else if path, err := exec.LookPath("less"); err == nil {
+		cmd = exec.Command(path, "-R") // keep the colors
+	} else {
+		fmt.Print(text)
+		return
+	}
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Print(text)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typingThrottle       = 3 * time.Second // min time between "start" events
+	typingIdleTimeout    = 5 * time.Second // send "stop" after this long without a keystroke
+	typingDisplayTimeout = 6 * time.Second // forget a typing user if we don't hear from them
+)
+
+// sends throttled typing start/stop events to the server
+type typingNotifier struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	enabled  bool // set once both us and the server support typing events
+	active   bool
+	lastSent time.Time
+	idle     *time.Timer
+}
+
+var typing = &typingNotifier{}
+
+// users currently typing, key: username, value: when to stop showing them
+var typingUsers = make(map[string]time.Time)
+
+func (t *typingNotifier) send(state string) {
+	jsonMsg := map[string]string{
+		"type":  "typing",
+		"user":  config["username"].(string),
+		"state": state,
+	}
+	jsonData, err := json.Marshal(jsonMsg)
+	if err != nil {
+		return
+	}
+	t.conn.Write(jsonData)
+}
+
+// called on every keystroke that changes the input
+func (t *typingNotifier) keystroke() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.enabled {
+		return
+	}
+
+	if !t.active || time.Since(t.lastSent) >= typingThrottle {
+		t.send("start")
+		t.active = true
+		t.lastSent = time.Now()
+	}
+
+	if t.idle != nil {
+		t.idle.Stop()
+	}
+	t.idle = time.AfterFunc(typingIdleTimeout, t.stop)
+}
+
+// called when the message is sent, the input is cleared, or the user goes idle
+func (t *typingNotifier) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle != nil {
+		t.idle.Stop()
+		t.idle = nil
+	}
+	if t.enabled && t.active {
+		t.send("stop")
+	}
+	t.active = false
+}
+
+// updates who is typing, from a "typing" event sent by the server
+func setTyping(user string, state string) {
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	if state == "start" && !muteList[user] {
+		typingUsers[user] = time.Now().Add(typingDisplayTimeout)
+	} else {
+		delete(typingUsers, user)
+	}
+	drawStatusLine()
+	drawInputLine()
+}
+
+// drops typing users we haven't heard from in a while
+func expireTypingUsers() {
+	for range time.Tick(time.Second) {
+		screenMutex.Lock()
+		changed := false
+		for user, expiry := range typingUsers {
+			if time.Now().After(expiry) {
+				delete(typingUsers, user)
+				changed = true
+			}
+		}
+		if changed {
+			drawStatusLine()
+			drawInputLine()
+		}
+		screenMutex.Unlock()
+	}
+}
+
+// builds the "alice is typing…" text, caller must hold screenMutex
+func typingStatusText() string {
+	users := make([]string, 0, len(typingUsers))
+	for user := range typingUsers {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	switch len(users) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s is typing…", users[0])
+	case 2:
+		return fmt.Sprintf("%s and %s are typing…", users[0], users[1])
+	case 3:
+		return fmt.Sprintf("%s are typing…", strings.Join(users, ", "))
+	default:
+		return fmt.Sprintf("%d people are typing…", len(users))
+	}
+}
+
+// draws the status line above the input (typing users, scrollback and unseen mentions), caller must hold screenMutex
+func drawStatusLine() {
+	if plainMode {
+		return
+	}
+	_, height := getTerminalSize()
+	moveCursor(1, height-2)
+	clearLine()
+	status := typingStatusText()
+	if scrolled := scrollStatusText(); scrolled != "" {
+		if status != "" {
+			status += " · "
+		}
+		status += scrolled
+	}
+	if unseenMentions > 0 {
+		if status != "" {
+			status += " · "
+		}
+		status += fmt.Sprintf("%d new mention(s), //mentions to view", unseenMentions)
+	}
+	if status != "" {
+		fmt.Print("\033[2m" + status + ansiColors["reset"]) // dim
+	}
+	drawStatusBar()
+}
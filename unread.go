@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// messages after this ID arrived while we were away, 0 when there's no divider to draw, guarded by screenMutex
+var unreadAfter int64
+
+// the server told us how many messages we missed since we were last here
+func setUnread(jsonMsg map[string]string) {
+	var count int
+	fmt.Sscanf(jsonMsg["count"], "%d", &count)
+	screenMutex.Lock()
+	unreadAfter = parseMessageID(jsonMsg["since"])
+	screenMutex.Unlock()
+	addServerMessage(fmt.Sprintf("%d unread message(s) since you were last here.", count), "bold_yellow")
+	redrawMessages()
+}
+
+// hides the divider once we've caught up, which we take sending a message to mean
+func clearUnread() {
+	screenMutex.Lock()
+	defer screenMutex.Unlock()
+	unreadAfter = 0
+}
+
+// whether the "new messages" divider goes right above this entry
+func isFirstUnread(entry *chatEntry, after int64) bool {
+	return after != 0 && !entry.notice && entry.id > after && entry.user != config["username"]
+}
+
+func unreadDivider() string {
+	return themeColorCode() + "─── new messages ───" + ansiColors["reset"]
+}